@@ -0,0 +1,220 @@
+package main
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The activity feed is derived from the history tables alone: Every history
+// row is the start of something happening to a list or a todo, and comparing
+// it to the row right before it tells what happened. A row that ends without
+// anything right after it is a deletion, and a row that starts after a gap is
+// a restore of something that was deleted.
+
+type ActivityKind string
+
+const (
+	ActivityListCreated     ActivityKind = "list_created"
+	ActivityListRenamed     ActivityKind = "list_renamed"
+	ActivityListDeleted     ActivityKind = "list_deleted"
+	ActivityListRestored    ActivityKind = "list_restored"
+	ActivityTodoCreated     ActivityKind = "todo_created"
+	ActivityTodoRenamed     ActivityKind = "todo_renamed"
+	ActivityTodoCompleted   ActivityKind = "todo_completed"
+	ActivityTodoReactivated ActivityKind = "todo_reactivated"
+	ActivityTodoDeleted     ActivityKind = "todo_deleted"
+	ActivityTodoRestored    ActivityKind = "todo_restored"
+)
+
+// Activity is a single thing that happened to a todo list or a todo.
+type Activity struct {
+	At       time.Time    `db:"at" json:"at"`
+	Kind     ActivityKind `db:"kind" json:"kind"`
+	ListID   TodoListID   `db:"todo_list_id" json:"todo_list_id"`
+	ListName string       `db:"list_name" json:"list_name"`
+	TodoID   *TodoID      `db:"todo_id" json:"todo_id,omitempty"`
+	// Description is the todo's description after the change.
+	Description *string `db:"description" json:"description,omitempty"`
+	// OldValue is the list name or todo description before a rename.
+	OldValue *string `db:"old_value" json:"old_value,omitempty"`
+	// ChangedBy and ChangeSetID are unknown for deletions, as they don't write
+	// any history rows of their own.
+	ChangedBy   *string      `db:"changed_by" json:"changed_by"`
+	ChangeSetID *ChangeSetID `db:"change_set_id" json:"change_set_id"`
+
+	ListHistoryID *TodoListHistoryID `db:"list_history_id" json:"list_history_id,omitempty"`
+	TodoHistoryID *TodoHistoryID     `db:"todo_history_id" json:"todo_history_id,omitempty"`
+
+	// CursorID breaks ties between activities happening at the same time,
+	// which all activities in a change set do.
+	CursorID uuid.UUID `db:"cursor_id" json:"-"`
+}
+
+func (a Activity) Cursor() ActivityCursor {
+	return ActivityCursor{At: a.At, ID: a.CursorID}
+}
+
+// ActivityCursor is the position of an activity in the feed, for paging.
+type ActivityCursor struct {
+	At time.Time `json:"at"`
+	ID uuid.UUID `json:"id"`
+}
+
+// ActivityFilter picks which part of the feed to return. All fields are
+// optional, except for Limit.
+type ActivityFilter struct {
+	ListID *TodoListID
+	// From is inclusive, To is exclusive.
+	From *time.Time
+	To   *time.Time
+	// Before returns the activities after the cursor, going back in time.
+	Before *ActivityCursor
+	Limit  int
+}
+
+// GetActivity returns the activities matching the filter, newest first.
+func GetActivity(tx *Tx, filter ActivityFilter) ([]Activity, error) {
+	var before *time.Time
+	var beforeID *uuid.UUID
+	if filter.Before != nil {
+		before = &filter.Before.At
+		beforeID = &filter.Before.ID
+	}
+
+	var acts []Activity
+	err := tx.Select(&acts, `
+SELECT a.*
+FROM (
+  -- list rows, compared to the row right before them
+  SELECT LOWER(tlh.systime) AS at
+       , CASE WHEN prev.history_id IS NOT NULL
+                THEN CASE WHEN prev.name <> tlh.name THEN 'list_renamed' END
+              WHEN EXISTS (SELECT 1
+                           FROM todo_lists_history older
+                           WHERE older.todo_list_id = tlh.todo_list_id
+                             AND older.systime << tlh.systime)
+                THEN 'list_restored'
+              ELSE 'list_created'
+         END AS kind
+       , tlh.todo_list_id
+       , tlh.name AS list_name
+       , CAST(NULL AS uuid) AS todo_id
+       , CAST(NULL AS text) AS description
+       , prev.name AS old_value
+       , tlh.changed_by
+       , tlh.change_set_id
+       , tlh.history_id AS list_history_id
+       , CAST(NULL AS uuid) AS todo_history_id
+       , tlh.history_id AS cursor_id
+  FROM todo_lists_history tlh
+  LEFT JOIN todo_lists_history prev
+    ON prev.todo_list_id = tlh.todo_list_id
+   AND UPPER(prev.systime) = LOWER(tlh.systime)
+
+  UNION ALL
+
+  -- list rows ending without anything right after them
+  SELECT UPPER(tlh.systime) AS at
+       , 'list_deleted' AS kind
+       , tlh.todo_list_id
+       , tlh.name AS list_name
+       , NULL, NULL, NULL, NULL, NULL
+       , tlh.history_id AS list_history_id
+       , NULL
+       , tlh.history_id AS cursor_id
+  FROM todo_lists_history tlh
+  WHERE NOT UPPER_INF(tlh.systime)
+    AND NOT EXISTS (SELECT 1
+                    FROM todo_lists_history next
+                    WHERE next.todo_list_id = tlh.todo_list_id
+                      AND LOWER(next.systime) = UPPER(tlh.systime))
+
+  UNION ALL
+
+  -- todo rows, compared to the row right before them
+  SELECT LOWER(th.systime) AS at
+       , CASE WHEN prev.history_id IS NOT NULL
+                THEN CASE WHEN NOT prev.completed AND th.completed THEN 'todo_completed'
+                          WHEN prev.completed AND NOT th.completed THEN 'todo_reactivated'
+                          WHEN prev.description <> th.description THEN 'todo_renamed'
+                     END
+              WHEN EXISTS (SELECT 1
+                           FROM todos_history older
+                           WHERE older.todo_id = th.todo_id
+                             AND older.systime << th.systime)
+                THEN 'todo_restored'
+              ELSE 'todo_created'
+         END AS kind
+       , th.todo_list_id
+       , list.name AS list_name
+       , th.todo_id
+       , th.description
+       , prev.description AS old_value
+       , th.changed_by
+       , th.change_set_id
+       , NULL
+       , th.history_id AS todo_history_id
+       , th.history_id AS cursor_id
+  FROM todos_history th
+  LEFT JOIN todos_history prev
+    ON prev.todo_id = th.todo_id
+   AND UPPER(prev.systime) = LOWER(th.systime)
+  CROSS JOIN LATERAL (SELECT tlh.name
+                      FROM todo_lists_history tlh
+                      WHERE tlh.todo_list_id = th.todo_list_id
+                        AND LOWER(tlh.systime) <= LOWER(th.systime)
+                      ORDER BY tlh.systime DESC
+                      LIMIT 1) AS list
+
+  UNION ALL
+
+  -- todo rows ending without anything right after them. Todos deleted along
+  -- with their list are left out, the list deletion says it all.
+  SELECT UPPER(th.systime) AS at
+       , 'todo_deleted' AS kind
+       , th.todo_list_id
+       , list.name AS list_name
+       , th.todo_id
+       , th.description
+       , NULL, NULL, NULL, NULL
+       , th.history_id AS todo_history_id
+       , th.history_id AS cursor_id
+  FROM todos_history th
+  CROSS JOIN LATERAL (SELECT tlh.name, tlh.systime
+                      FROM todo_lists_history tlh
+                      WHERE tlh.todo_list_id = th.todo_list_id
+                        AND LOWER(tlh.systime) < UPPER(th.systime)
+                      ORDER BY tlh.systime DESC
+                      LIMIT 1) AS list
+  WHERE NOT UPPER_INF(th.systime)
+    AND NOT EXISTS (SELECT 1
+                    FROM todos_history next
+                    WHERE next.todo_id = th.todo_id
+                      AND LOWER(next.systime) = UPPER(th.systime))
+    AND NOT (UPPER(list.systime) = UPPER(th.systime)
+             AND NOT EXISTS (SELECT 1
+                             FROM todo_lists_history next
+                             WHERE next.todo_list_id = th.todo_list_id
+                               AND LOWER(next.systime) = UPPER(th.systime)))
+) AS a
+WHERE a.kind IS NOT NULL
+  AND (CAST(:list_id AS uuid) IS NULL OR a.todo_list_id = CAST(:list_id AS uuid))
+  AND (CAST(:from AS timestamptz) IS NULL OR a.at >= CAST(:from AS timestamptz))
+  AND (CAST(:to AS timestamptz) IS NULL OR a.at < CAST(:to AS timestamptz))
+  AND (CAST(:before AS timestamptz) IS NULL
+       OR (a.at, a.cursor_id) < (CAST(:before AS timestamptz), CAST(:before_id AS uuid)))
+ORDER BY a.at DESC, a.cursor_id DESC
+LIMIT :limit`, QueryArgs{
+		"list_id":   filter.ListID,
+		"from":      filter.From,
+		"to":        filter.To,
+		"before":    before,
+		"before_id": beforeID,
+		"limit":     filter.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return acts, nil
+}
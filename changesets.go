@@ -0,0 +1,111 @@
+package main
+
+import "time"
+
+type ChangeSet struct {
+	ID        ChangeSetID `db:"change_set_id" json:"change_set_id"`
+	CreatedAt time.Time   `db:"created_at" json:"created_at"`
+	Actor     string      `db:"actor" json:"actor"`
+	Route     *string     `db:"route" json:"route"`
+	Message   *string     `db:"message" json:"message"`
+}
+
+// ChangeSetSummary is a change set along with how many history rows it wrote.
+type ChangeSetSummary struct {
+	ChangeSet
+	TodoListRevisions int `db:"todo_list_revisions" json:"todo_list_revisions"`
+	TodoRevisions     int `db:"todo_revisions" json:"todo_revisions"`
+}
+
+var changeSetCols = TableColumns{"change_set_id", "created_at", "actor", "route", "message"}
+
+// ChangeSetCursor is the position of a change set in the listing, for paging.
+// Change sets can be made at the same time, so the id breaks ties.
+type ChangeSetCursor struct {
+	CreatedAt time.Time
+	ID        ChangeSetID
+}
+
+func (cs ChangeSet) Cursor() ChangeSetCursor {
+	return ChangeSetCursor{CreatedAt: cs.CreatedAt, ID: cs.ID}
+}
+
+// GetChangeSets returns the newest change sets after the cursor, going back in
+// time, or the newest overall if before is nil.
+func GetChangeSets(tx *Tx, before *ChangeSetCursor, limit int) ([]ChangeSetSummary, error) {
+	var beforeAt *time.Time
+	var beforeID *ChangeSetID
+	if before != nil {
+		beforeAt = &before.CreatedAt
+		beforeID = &before.ID
+	}
+
+	var css []ChangeSetSummary
+	err := tx.Select(&css, `
+SELECT `+changeSetCols.OnAlias("cs").String()+`
+     , (SELECT COUNT(*)
+        FROM todo_lists_history tlh
+        WHERE tlh.change_set_id = cs.change_set_id) AS todo_list_revisions
+     , (SELECT COUNT(*)
+        FROM todos_history th
+        WHERE th.change_set_id = cs.change_set_id) AS todo_revisions
+FROM change_sets cs
+WHERE CAST(:before AS timestamptz) IS NULL
+   OR (cs.created_at, cs.change_set_id) < (CAST(:before AS timestamptz), CAST(:before_id AS uuid))
+ORDER BY cs.created_at DESC, cs.change_set_id DESC
+LIMIT :limit`, QueryArgs{
+		"before":    beforeAt,
+		"before_id": beforeID,
+		"limit":     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return css, nil
+}
+
+func GetChangeSetByID(tx *Tx, csid ChangeSetID) (*ChangeSet, error) {
+	var cs ChangeSet
+	err := tx.Get(&cs, `
+SELECT `+changeSetCols.OnAlias("cs").String()+`
+FROM change_sets cs
+WHERE cs.change_set_id = :csid`, QueryArgs{
+		"csid": csid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cs, nil
+}
+
+// ChangeSetRevisions are the history rows a change set wrote.
+type ChangeSetRevisions struct {
+	TodoLists []TodoListRevision
+	Todos     TodoRevisions
+}
+
+func GetChangeSetRevisions(tx *Tx, csid ChangeSetID) (*ChangeSetRevisions, error) {
+	var csr ChangeSetRevisions
+	err := tx.Select(&csr.TodoLists, `
+SELECT `+todoListRevisionCols.String()+`
+FROM todo_lists_history tlh
+WHERE tlh.change_set_id = :csid
+ORDER BY tlh.name ASC`, QueryArgs{
+		"csid": csid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Select(&csr.Todos, `
+SELECT `+todoRevisionCols.String()+`
+FROM todos_history th
+WHERE th.change_set_id = :csid
+ORDER BY th.description ASC`, QueryArgs{
+		"csid": csid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &csr, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// checkpointEvery is how many revisions of a todo list there are between its
+// checkpoints, see migrations/013_checkpoints.up.sql. The partial index in
+// migrations/019_checkpoint_revisions.up.sql must use the same number.
+const checkpointEvery = 100
+
+// WriteTodoListCheckpoints makes the missing checkpoints of all todo lists
+// after their latest one, and returns how many it made.
+//
+// A checkpoint must have every row that was current at its revision, so we
+// can't make it while a transaction that started before the revision is still
+// running: It could still insert rows starting at its own start time. Those
+// revisions are left for the next time. The start times of other roles'
+// transactions are only visible to superusers and members of
+// pg_read_all_stats, so the app's role must be one of them if other roles
+// write to the todo lists. Without it, we refuse to make checkpoints while
+// other roles' sessions are connected.
+func WriteTodoListCheckpoints(tx *Tx) (int64, error) {
+	// This must be read before the checkpoints are made: Transactions that
+	// have finished by now are then visible to the statement making them.
+	var horizon struct {
+		At     time.Time `db:"horizon"`
+		Hidden int64     `db:"hidden"`
+	}
+	err := tx.Get(&horizon, `
+SELECT LEAST(NOW(), MIN(a.xact_start)) AS horizon
+     , COUNT(*) FILTER (WHERE a.state IS NULL
+                          AND a.backend_type = 'client backend') AS hidden
+FROM pg_stat_activity a
+WHERE a.pid <> pg_backend_pid()`, QueryArgs{})
+	if err != nil {
+		return 0, err
+	}
+	if horizon.Hidden != 0 {
+		return 0, fmt.Errorf("can't see when the transactions of %d sessions started, grant pg_read_all_stats to make checkpoints", horizon.Hidden)
+	}
+
+	// the revision condition is inlined so that it matches the partial index.
+	var made int64
+	err = tx.Get(&made, `
+WITH made AS (
+  INSERT INTO todo_list_checkpoints (todo_list_id, revision, taken_at, todo_history_ids)
+  SELECT tlh.todo_list_id
+       , tlh.revision
+       , LOWER(tlh.systime)
+       , ARRAY(SELECT th.history_id
+               FROM todos_history th
+               WHERE th.todo_list_id = tlh.todo_list_id
+                 AND th.systime @> LOWER(tlh.systime))
+  FROM todo_lists_history tlh
+  WHERE tlh.revision % `+strconv.Itoa(checkpointEvery)+` = 0
+    AND tlh.revision > COALESCE((SELECT MAX(c.revision)
+                                 FROM todo_list_checkpoints c
+                                 WHERE c.todo_list_id = tlh.todo_list_id), 0)
+    AND LOWER(tlh.systime) < CAST(:horizon AS timestamptz)
+  RETURNING 1
+)
+SELECT COUNT(*) FROM made`, QueryArgs{
+		"horizon": horizon.At,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return made, nil
+}
+
+func writeTodoListCheckpoints(db *sqlx.DB) error {
+	return RunInTx(context.Background(), db, TxMeta{Route: "checkpoints"}, func(tx *Tx) error {
+		made, err := WriteTodoListCheckpoints(tx)
+		if err != nil {
+			return err
+		}
+		if made != 0 {
+			logrus.WithField("checkpoints", made).Info("made todo list checkpoints")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Commands are run instead of the HTTP server when the app is started with
+// arguments, e.g.
+//
+//	./time-travelling-todo-lists-in-postgres provision-history mytable
+
+type command struct {
+	usage string
+	run   func(db *sqlx.DB, args []string) error
+}
+
+var commands = map[string]command{
+	"provision-history": {
+		usage: "[-print] <table>: make the history table and triggers for a table",
+		run:   provisionHistoryCommand,
+	},
+	"check-history": {
+		usage: "[-repair]: check that the history tables are in line with their tables",
+		run:   checkHistoryCommand,
+	},
+	"compact-history": {
+		usage: "[-policy tiers] [-dry-run]: thin out old history according to a retention policy",
+		run:   compactHistoryCommand,
+	},
+	"detach-history-partitions": {
+		usage: "-before <yyyy-mm>: detach the history partitions for the months before, to archive them",
+		run:   detachHistoryPartitionsCommand,
+	},
+	"verify-history": {
+		usage: ": check the hash chains of the history tables for altered or removed rows",
+		run:   verifyHistoryCommand,
+	},
+	"purge-todo-list": {
+		usage: "<todo list id>: permanently remove all history of a deleted todo list",
+		run:   purgeTodoListCommand,
+	},
+	"stress-history": {
+		usage: "[-workers n] [-changes n] [-max-delay d] [-keep]: change a todo concurrently and check its history",
+		run:   stressHistoryCommand,
+	},
+}
+
+func runCommand(db *sqlx.DB, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		var names []string
+		for name, cmd := range commands {
+			names = append(names, "  "+name+" "+cmd.usage)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown command %q, available commands are:\n%s", name, strings.Join(names, "\n"))
+	}
+	return cmd.run(db, args)
+}
+
+// commandTxMeta describes the transactions made by a command.
+func commandTxMeta(name string) TxMeta {
+	return TxMeta{
+		Route: "command " + name,
+	}
+}
+
+func provisionHistoryCommand(db *sqlx.DB, args []string) error {
+	flags := flag.NewFlagSet("provision-history", flag.ContinueOnError)
+	printSQL := flags.Bool("print", false, "print the SQL instead of running it, e.g. to put it in a migration")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: provision-history [-print] <table>")
+	}
+	table := flags.Arg(0)
+
+	return RunInTx(context.Background(), db, commandTxMeta("provision-history"), func(tx *Tx) error {
+		if !*printSQL {
+			return ProvisionHistoryTable(tx, table)
+		}
+		stmts, err := HistoryTableDDL(tx, table)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range stmts {
+			fmt.Fprintf(os.Stdout, "%s;\n\n", stmt)
+		}
+		return nil
+	})
+}
+
+func stressHistoryCommand(db *sqlx.DB, args []string) error {
+	flags := flag.NewFlagSet("stress-history", flag.ContinueOnError)
+	var opts StressOptions
+	flags.IntVar(&opts.Workers, "workers", 20, "number of concurrent workers")
+	flags.IntVar(&opts.Changes, "changes", 20, "number of changes each worker makes")
+	flags.DurationVar(&opts.MaxDelay, "max-delay", 50*time.Millisecond, "longest delay inside a transaction before it changes the todo")
+	flags.BoolVar(&opts.Keep, "keep", false, "keep the todo list instead of purging it afterwards")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: stress-history [-workers n] [-changes n] [-max-delay d] [-keep]")
+	}
+	// every worker needs its own connection to be concurrent.
+	db.SetMaxOpenConns(opts.Workers + 1)
+
+	res, err := StressHistory(db, opts)
+	if res != nil {
+		fmt.Fprintf(os.Stdout, "%d changes committed, %d failed after %d attempts, on todo %s in list %s\n",
+			res.Committed, res.Failed, maxTxAttempts, res.TodoID, res.ListID)
+	}
+	return err
+}
+
+func compactHistoryCommand(db *sqlx.DB, args []string) error {
+	flags := flag.NewFlagSet("compact-history", flag.ContinueOnError)
+	policyStr := flags.String("policy", DefaultRetentionPolicy.String(), "retention tiers as <age>:<day|week|month|year|drop>, youngest first")
+	dryRun := flags.Bool("dry-run", false, "report what would be removed without removing it")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: compact-history [-policy tiers] [-dry-run]")
+	}
+	policy, err := ParseRetentionPolicy(*policyStr)
+	if err != nil {
+		return err
+	}
+
+	var res *CompactionResult
+	err = RunInTx(context.Background(), db, commandTxMeta("compact-history"), func(tx *Tx) error {
+		compact := func() error {
+			var err error
+			res, err = CompactHistory(tx, policy, time.Now())
+			return err
+		}
+		if *dryRun {
+			return tx.DryRun(compact)
+		}
+		return compact()
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "removed %d todo list history rows, %d todo history rows and %d empty change sets\n",
+		res.TodoListRows, res.TodoRows, res.ChangeSets)
+	return nil
+}
+
+func detachHistoryPartitionsCommand(db *sqlx.DB, args []string) error {
+	flags := flag.NewFlagSet("detach-history-partitions", flag.ContinueOnError)
+	beforeStr := flags.String("before", "", "detach the partitions for the months before this one, as yyyy-mm")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 || *beforeStr == "" {
+		return errors.New("usage: detach-history-partitions -before <yyyy-mm>")
+	}
+	before, err := time.Parse("2006-01", *beforeStr)
+	if err != nil {
+		return err
+	}
+
+	var detached []string
+	err = RunInTx(context.Background(), db, commandTxMeta("detach-history-partitions"), func(tx *Tx) error {
+		var err error
+		detached, err = DetachHistoryPartitions(tx, before)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, partition := range detached {
+		fmt.Fprintln(os.Stdout, partition)
+	}
+	return nil
+}
+
+func checkHistoryCommand(db *sqlx.DB, args []string) error {
+	flags := flag.NewFlagSet("check-history", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "repair the problems that can be repaired")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("usage: check-history [-repair]")
+	}
+
+	var problems []HistoryProblem
+	err = RunInTx(context.Background(), db, commandTxMeta("check-history"), func(tx *Tx) error {
+		var err error
+		problems, err = CheckHistoryIntegrity(tx, *repair)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var unrepaired int
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stdout, problem)
+		if !problem.Repaired {
+			unrepaired++
+		}
+	}
+	if unrepaired != 0 {
+		return fmt.Errorf("found %d history problems that are not repaired", unrepaired)
+	}
+	return nil
+}
+
+func purgeTodoListCommand(db *sqlx.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: purge-todo-list <todo list id>")
+	}
+	var tlid TodoListID
+	err := tlid.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	return RunInTx(context.Background(), db, commandTxMeta("purge-todo-list"), func(tx *Tx) error {
+		return PurgeTodoList(tx, tlid)
+	})
+}
+
+func verifyHistoryCommand(db *sqlx.DB, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: verify-history")
+	}
+
+	var report *HistoryChainReport
+	err := RunInTx(context.Background(), db, commandTxMeta("verify-history"), func(tx *Tx) error {
+		var err error
+		report, err = VerifyHistoryChains(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, problem := range report.Problems {
+		fmt.Fprintln(os.Stdout, problem)
+	}
+	fmt.Fprintf(os.Stdout, "verified %d history rows, head digest %s\n", report.Rows, report.HeadDigest)
+	if len(report.Problems) != 0 {
+		return fmt.Errorf("found %d history rows that have been tampered with", len(report.Problems))
+	}
+	return nil
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 )
 
@@ -96,7 +98,80 @@ func (tx *Tx) UpdateOne(query string, args QueryArgs) error {
 	return nil
 }
 
-func RunInTx(ctx context.Context, db *sqlx.DB, f func(tx *Tx) error) error {
+// DryRun runs f inside a savepoint and rolls back whatever it did afterwards,
+// leaving the rest of the transaction as it was.
+func (tx *Tx) DryRun(f func() error) error {
+	_, err := tx.tx.Exec("SAVEPOINT dry_run")
+	if err != nil {
+		return err
+	}
+	fErr := f()
+	_, err = tx.tx.Exec("ROLLBACK TO SAVEPOINT dry_run")
+	if err != nil {
+		return err
+	}
+	_, err = tx.tx.Exec("RELEASE SAVEPOINT dry_run")
+	if err != nil {
+		return err
+	}
+	return fErr
+}
+
+// TxMeta describes a transaction. It ends up in the change set that groups the
+// history rows the transaction writes.
+type TxMeta struct {
+	// Actor is who made the changes.
+	Actor string
+	// Route is what caused the changes, typically the HTTP route.
+	Route string
+	// Message is an optional note on why the changes were made.
+	Message string
+}
+
+// setMeta makes meta available to the history triggers through the app.*
+// settings for the rest of the transaction.
+func (tx *Tx) setMeta(meta TxMeta) error {
+	return tx.Exec(`
+SELECT set_config('app.actor', :actor, true)
+     , set_config('app.route', :route, true)
+     , set_config('app.change_message', :message, true)`, QueryArgs{
+		"actor":   meta.Actor,
+		"route":   meta.Route,
+		"message": meta.Message,
+	})
+}
+
+// maxTxAttempts is how many times RunInTx tries a transaction before giving up
+// on serialization failures.
+const maxTxAttempts = 5
+
+// RunInTx runs f inside a transaction. All changes made in the transaction end
+// up in the same change set, described by meta.
+//
+// If the transaction fails because it conflicts with a concurrent one, it's
+// retried from the start in a new transaction, so f may be called more than
+// once. f must therefore not do anything outside the transaction it can't
+// take back before it's done with it.
+func RunInTx(ctx context.Context, db *sqlx.DB, meta TxMeta, f func(tx *Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runInTx(ctx, db, meta, f)
+		if !isSerializationFailure(err) || attempt == maxTxAttempts || ctx.Err() != nil {
+			return err
+		}
+		logrus.WithError(err).WithField("attempt", attempt).Info("retrying transaction")
+	}
+}
+
+// isSerializationFailure returns true if err means the transaction was rolled
+// back because of concurrent transactions, and may succeed if retried.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	// class 40 is transaction rollback, e.g. serialization failures and
+	// deadlocks.
+	return errors.As(err, &pqErr) && pqErr.Code.Class() == "40"
+}
+
+func runInTx(ctx context.Context, db *sqlx.DB, meta TxMeta, f func(tx *Tx) error) error {
 	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
@@ -110,7 +185,11 @@ func RunInTx(ctx context.Context, db *sqlx.DB, f func(tx *Tx) error) error {
 			}
 		}
 	}()
-	err = f(&Tx{tx: tx})
+	wrappedTx := &Tx{tx: tx}
+	err = wrappedTx.setMeta(meta)
+	if err == nil {
+		err = f(wrappedTx)
+	}
 
 	didPanic = false
 	if err != nil {
@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+
+// TodoListDiff is the set of changes needed to go from one state of a todo
+// list to another.
+type TodoListDiff struct {
+	From *TodoListRevisionBase `json:"from,omitempty"`
+	To   *TodoListRevisionBase `json:"to,omitempty"`
+
+	OldName     string       `json:"old_name"`
+	NewName     string       `json:"new_name"`
+	Added       Todos        `json:"added"`
+	Removed     Todos        `json:"removed"`
+	Renamed     []TodoRename `json:"renamed"`
+	Completed   Todos        `json:"completed"`
+	Reactivated Todos        `json:"reactivated"`
+}
+
+// TodoRename is a todo that kept its identity, but got a new description.
+type TodoRename struct {
+	Todo           Todo   `json:"todo"`
+	OldDescription string `json:"old_description"`
+}
+
+func (d *TodoListDiff) ListRenamed() bool {
+	return d.OldName != d.NewName
+}
+
+func (d *TodoListDiff) Empty() bool {
+	return !d.ListRenamed() &&
+		len(d.Added) == 0 &&
+		len(d.Removed) == 0 &&
+		len(d.Renamed) == 0 &&
+		len(d.Completed) == 0 &&
+		len(d.Reactivated) == 0
+}
+
+// DiffTodoLists compares the todos on identity, not on contents: A todo that
+// is deleted and then recreated with the same description is reported as
+// removed and added, whereas a todo restored from the history table keeps its
+// ID and is reported by what changed on it.
+func DiffTodoLists(from, to TodoList) TodoListDiff {
+	diff := TodoListDiff{
+		OldName: from.Name,
+		NewName: to.Name,
+	}
+
+	fromTodos := make(map[TodoID]Todo, len(from.Todos))
+	for _, todo := range from.Todos {
+		fromTodos[todo.ID] = todo
+	}
+	toTodos := make(map[TodoID]Todo, len(to.Todos))
+	for _, todo := range to.Todos {
+		toTodos[todo.ID] = todo
+	}
+
+	for _, todo := range from.Todos {
+		if _, ok := toTodos[todo.ID]; !ok {
+			diff.Removed = append(diff.Removed, todo)
+		}
+	}
+
+	for _, todo := range to.Todos {
+		old, ok := fromTodos[todo.ID]
+		if !ok {
+			diff.Added = append(diff.Added, todo)
+			continue
+		}
+		if old.Description != todo.Description {
+			diff.Renamed = append(diff.Renamed, TodoRename{
+				Todo:           todo,
+				OldDescription: old.Description,
+			})
+		}
+		if !old.Completed && todo.Completed {
+			diff.Completed = append(diff.Completed, todo)
+		}
+		if old.Completed && !todo.Completed {
+			diff.Reactivated = append(diff.Reactivated, todo)
+		}
+	}
+	return diff
+}
+
+// GetTodoListRevisionDiff returns the changes between two revisions of a todo
+// list.
+func GetTodoListRevisionDiff(tx *Tx, tlid TodoListID, from, to TodoListHistoryID) (*TodoListDiff, error) {
+	fromRev, err := GetTodoListRevisionByID(tx, from)
+	if err != nil {
+		return nil, err
+	}
+	toRev, err := GetTodoListRevisionByID(tx, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, rev := range []*TodoListRevision{fromRev, toRev} {
+		if rev.ID != tlid {
+			return nil, fmt.Errorf("revision %s does not belong to todo list %s", rev.HistoryID, tlid)
+		}
+	}
+
+	diff := DiffTodoLists(fromRev.Snapshot(), toRev.Snapshot())
+	diff.From = &fromRev.TodoListRevisionBase
+	diff.To = &toRev.TodoListRevisionBase
+	return &diff, nil
+}
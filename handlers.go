@@ -5,16 +5,37 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	g "github.com/maragudk/gomponents"
 	c "github.com/maragudk/gomponents/components"
 	. "github.com/maragudk/gomponents/html"
 )
 
 func indexHandler(ctx *Context) (g.Node, error) {
+	asOf, err := parseAsOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if asOf != nil {
+		tls, err := GetAllTodoListsAsOf(ctx.Tx, *asOf)
+		if err != nil {
+			return nil, err
+		}
+		return pageNode("Todo Lists as of "+fmtTime(*asOf),
+			[]g.Node{
+				H1(g.Text("Your Todo Lists")),
+				asOfBanner(*asOf, "/"),
+				todoListTable(tls, asOf),
+				timeTravelForm(),
+			},
+		), nil
+	}
+
 	tls, err := GetAllTodoLists(ctx.Tx)
 	if err != nil {
 		return nil, err
@@ -23,12 +44,18 @@ func indexHandler(ctx *Context) (g.Node, error) {
 	return pageNode("Todo Lists",
 		[]g.Node{
 			H1(g.Text("Your Todo Lists")),
-			todoListTable(tls),
+			todoListTable(tls, nil),
 			newTodoListForm(),
+			timeTravelForm(),
+			actorNote(ctx.Actor),
 		},
 	), nil
 }
 
+func actorNote(actor string) g.Node {
+	return P(g.Text("Changes are recorded as made by "), Strong(g.Text(actor)), g.Text("."))
+}
+
 func newTodoListForm() g.Node {
 	return FormEl(Method("post"), Action("/todo-lists"),
 		H3(g.Text("Make a new list")),
@@ -37,19 +64,29 @@ func newTodoListForm() g.Node {
 		Button(g.Text("Create")))
 }
 
-func todoListTable(tls []TodoListBase) g.Node {
+func timeTravelForm() g.Node {
+	return FormEl(Method("get"), Action("/"),
+		H3(g.Text("Time travel")),
+		Label(For("as_of"), g.Text("Show everything as it was at:")),
+		Input(Type("datetime-local"), Name("as_of"), Step("1"), Required()),
+		Button(g.Text("Go")))
+}
+
+func todoListTable(tls []TodoListBase, asOf *time.Time) g.Node {
 	return table(
 		[]string{"Name", "Created", "Last Updated", ""},
-		g.Map(tls, todoListRow),
+		g.Map(tls, func(tl TodoListBase) g.Node {
+			return todoListRow(tl, asOf)
+		}),
 	)
 }
 
-func todoListRow(tl TodoListBase) g.Node {
+func todoListRow(tl TodoListBase, asOf *time.Time) g.Node {
 	return Tr(
-		Td(A(Href(tl.ID.Href()), g.Text(tl.Name))),
+		Td(A(Href(withAsOf(tl.ID.Href(), asOf)), g.Text(tl.Name))),
 		Td(g.Text(fmtTime(tl.CreatedAt))),
 		Td(g.Text(fmtTime(tl.UpdatedAt))),
-		Td(postButton(tl.ID.HrefTo("delete"), "delete")),
+		Td(g.If(asOf == nil, postButton(tl.ID.HrefTo("delete"), "delete"))),
 	)
 }
 
@@ -76,17 +113,42 @@ func getTodoListHandler(ctx *Context) (g.Node, error) {
 		return nil, err
 	}
 
+	asOf, err := parseAsOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	validAsOf, err := parseOptionalTimeParam(ctx, "valid_as_of")
+	if err != nil {
+		return nil, err
+	}
+	if asOf != nil || validAsOf != nil {
+		if asOf == nil {
+			// what we know right now about how things were back then.
+			now := time.Now()
+			asOf = &now
+		}
+		return todoListAsOfPage(ctx, tlid, *asOf, validAsOf)
+	}
+
 	tl, err := GetTodoListByID(ctx.Tx, tlid)
 	if err != nil {
 		return nil, err
 	}
 
+	undoState, err := GetUndoState(ctx.Tx, tlid)
+	if err != nil {
+		return nil, err
+	}
+
 	completed := tl.Todos.FilterByCompleted(true)
 	unfinished := tl.Todos.FilterByCompleted(false)
 
 	return pageNode("Todo List - "+tl.Name,
 		[]g.Node{
 			H1(g.Text(tl.Name)),
+			forkedFromNode(tl.ForkedFrom),
+			P(g.If(undoState.CanUndo, postButton(tlid.HrefTo("undo"), "Undo")),
+				g.If(undoState.CanRedo, postButton(tlid.HrefTo("redo"), "Redo"))),
 			newTodosForm(tlid),
 			g.If(len(unfinished) != 0, g.Group([]g.Node{
 				H3(g.Text("Todos")),
@@ -96,15 +158,72 @@ func getTodoListHandler(ctx *Context) (g.Node, error) {
 				H3(g.Text("Completed")),
 				Table(TBody(g.Map(completed, completedTodoRow)...)),
 			})),
-			P(A(Href(tl.ID.HrefTo("revisions")), g.Text("Revisions"))),
+			P(A(Href(tl.ID.HrefTo("revisions")), g.Text("Revisions")), g.Text(" "),
+				A(Href("/activity?list="+tl.ID.String()), g.Text("Activity"))),
+			validTimeTravelForm(tlid, nil),
+		},
+	), nil
+}
+
+// validTimeTravelForm shows the list as it was in the real world at some point
+// in time, as opposed to how the database saw it back then.
+func validTimeTravelForm(tlid TodoListID, asOf *time.Time) g.Node {
+	return FormEl(Method("get"), Action(tlid.Href()),
+		g.If(asOf != nil, Input(Type("hidden"), Name("as_of"), Value(derefTime(asOf).Format(time.RFC3339Nano)))),
+		Label(For("valid_as_of"), g.Text("Show the todos as they really were at:")),
+		Input(Type("datetime-local"), Name("valid_as_of"), Step("1"), Required()),
+		Button(g.Text("Go")))
+}
+
+func todoListAsOfPage(ctx *Context, tlid TodoListID, asOf time.Time, validAsOf *time.Time) (g.Node, error) {
+	tlr, err := GetTodoListRevisionAsOf(ctx.Tx, tlid, asOf, validAsOf)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("todo list %s did not exist as of %s", tlid, fmtTime(asOf))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	completed := tlr.Todos.FilterByCompleted(true)
+	unfinished := tlr.Todos.FilterByCompleted(false)
+
+	// a renderer without a current list never offers to restore anything.
+	var readOnly todoRevisionRenderer
+
+	return pageNode("Todo List - "+tlr.Name+" as of "+fmtTime(asOf),
+		[]g.Node{
+			H1(g.Text(tlr.Name)),
+			asOfBanner(asOf, tlid.Href()),
+			g.If(validAsOf != nil, P(Strong(g.Text("Showing the todos as they really were at "+
+				fmtTime(derefTime(validAsOf))+", including corrections recorded up to "+fmtTime(asOf)+".")))),
+			forkedFromNode(tlr.ForkedFrom),
+			g.If(len(unfinished) != 0, g.Group([]g.Node{
+				H3(g.Text("Todos")),
+				Table(TBody(g.Map(unfinished, readOnly.unfinishedRow)...)),
+			})),
+			g.If(len(completed) != 0, g.Group([]g.Node{
+				H3(g.Text("Completed")),
+				Table(TBody(g.Map(completed, readOnly.completedRow)...)),
+			})),
+			P(A(Href(withAsOf(tlid.HrefTo("revisions"), &asOf)), g.Text("Revisions"))),
+			validTimeTravelForm(tlid, &asOf),
 		},
 	), nil
 }
 
+func forkedFromNode(tlhid *TodoListHistoryID) g.Node {
+	if tlhid == nil {
+		return nil
+	}
+	return P(Em(g.Text("Forked from "), A(Href(tlhid.Href()), g.Text("this revision")), g.Text(".")))
+}
+
 func newTodosForm(tlid TodoListID) g.Node {
 	return FormEl(Method("post"), Action(tlid.HrefTo("new-todos")),
 		Label(For("new-todos"), g.Text("Make new todos (comma separated):")),
 		Input(Type("text"), Name("new-todos"), Required()),
+		Label(For("message"), g.Text("Note (optional):")),
+		Input(Type("text"), Name("message")),
 		Button(g.Text("Add")))
 }
 
@@ -112,14 +231,16 @@ func unfinishedTodoRow(todo Todo) g.Node {
 	return Tr(
 		Td(g.Text(todo.Description)),
 		Td(postButton(todo.ID.HrefTo("complete"), "Complete"),
-			postButton(todo.ID.HrefTo("delete"), "Delete")),
+			postButton(todo.ID.HrefTo("delete"), "Delete"),
+			A(Href(todo.ID.HrefTo("history")), g.Text("History"))),
 	)
 }
 func completedTodoRow(todo Todo) g.Node {
 	return Tr(
 		Td(S(g.Text(todo.Description))),
 		Td(postButton(todo.ID.HrefTo("reactivate"), "Reactivate"),
-			postButton(todo.ID.HrefTo("delete"), "Delete")),
+			postButton(todo.ID.HrefTo("delete"), "Delete"),
+			A(Href(todo.ID.HrefTo("history")), g.Text("History"))),
 	)
 }
 
@@ -168,47 +289,277 @@ func newTodosHandler(ctx *Context) error {
 	return nil
 }
 
-func getTodoListRevisionsHandler(ctx *Context) (g.Node, error) {
+func undoTodoListHandler(ctx *Context) error {
 	var tlid TodoListID
 	err := tlid.Parse(ctx.Param("tlid"))
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	err = UndoTodoList(ctx.Tx, tlid)
+	if err != nil {
+		return err
+	}
+
+	ctx.Redirect(http.StatusSeeOther, tlid.Href())
+	return nil
+}
+
+func redoTodoListHandler(ctx *Context) error {
+	var tlid TodoListID
+	err := tlid.Parse(ctx.Param("tlid"))
+	if err != nil {
+		return err
+	}
+
+	err = RedoTodoList(ctx.Tx, tlid)
+	if err != nil {
+		return err
+	}
+
+	ctx.Redirect(http.StatusSeeOther, tlid.Href())
+	return nil
+}
+
+const revisionsPerPage = 50
+
+// todoListRevisionsFromQuery reads the parameters shared by the revisions page
+// and the revisions API.
+func todoListRevisionsFromQuery(ctx *Context) (TodoListID, *TodoListRevisionsFilter, *TodoListRevisionsPage, error) {
+	var tlid TodoListID
+	err := tlid.Parse(ctx.Param("tlid"))
+	if err != nil {
+		return tlid, nil, nil, err
 	}
 
-	revs, err := GetTodoListRevisions(ctx.Tx, tlid)
+	filter := TodoListRevisionsFilter{
+		Changes: RevisionChanges(ctx.Query("changes")),
+		Limit:   revisionsPerPage,
+	}
+	filter.AsOf, err = parseAsOf(ctx)
+	if err != nil {
+		return tlid, nil, nil, err
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}, {"before", &filter.Before}} {
+		*param.dest, err = parseOptionalTimeParam(ctx, param.name)
+		if err != nil {
+			return tlid, nil, nil, err
+		}
+	}
+
+	revs, err := GetTodoListRevisions(ctx.Tx, tlid, filter)
+	if err != nil {
+		return tlid, nil, nil, err
+	}
+	page := TodoListRevisionsPage{Revisions: revs}
+	if len(revs) == filter.Limit {
+		page.Next = &revs[len(revs)-1].SysLower
+	}
+	return tlid, &filter, &page, nil
+}
+
+// TodoListRevisionsPage is a page of todo list revisions. Next is set if there
+// may be more revisions, and is passed in as before to get them.
+type TodoListRevisionsPage struct {
+	Revisions []TodoListRevisionSummary `json:"revisions"`
+	Next      *time.Time                `json:"next"`
+}
+
+func getTodoListRevisionsAPIHandler(ctx *Context) (any, error) {
+	_, _, page, err := todoListRevisionsFromQuery(ctx)
+	return page, err
+}
+
+func getTodoListRevisionsHandler(ctx *Context) (g.Node, error) {
+	tlid, filter, page, err := todoListRevisionsFromQuery(ctx)
 	if err != nil {
 		return nil, err
 	}
+	asOf := filter.AsOf
 
-	if len(revs) == 0 {
-		return nil, fmt.Errorf("no revisions for todo list with ID %s", tlid)
+	var rows []g.Node
+	for _, rev := range page.Revisions {
+		if rev.Deleted {
+			rows = append(rows, todoListTombstoneRow(rev.TodoListRevisionBase, asOf == nil))
+		}
+		rows = append(rows, todoListRevisionRow(tlid, rev, asOf))
 	}
 
-	rows := make([]g.Node, len(revs))
-	for i, rev := range revs {
-		revID := len(revs) - i
-		rows[i] = todoListRevisionRow(rev, revID)
+	var older g.Node
+	if page.Next != nil {
+		query := ctx.Request.URL.Query()
+		query.Set("before", page.Next.Format(time.RFC3339Nano))
+		older = P(A(Href(tlid.HrefTo("revisions")+"?"+query.Encode()), g.Text("Older revisions")))
 	}
 
 	return pageNode("Todo List Revisions for "+tlid.String(),
 		[]g.Node{
 			H1(g.Text("Todo List Revisions for " + tlid.String())),
-			table([]string{"Revision", "Valid from", "Valid to"},
-				rows),
+			g.If(asOf != nil, asOfBanner(derefTime(asOf), tlid.HrefTo("revisions"))),
+			todoListRevisionsFilterForm(tlid, filter),
+			g.If(len(rows) == 0, P(g.Text("No matching revisions."))),
+			g.If(len(rows) != 0, table([]string{"Revision", "Event", "Valid from", "Valid to", "Changed by", ""},
+				rows)),
+			older,
 		},
 	), nil
 }
 
-func todoListRevisionRow(tlhb TodoListRevisionBase, versionID int) g.Node {
+func todoListRevisionsFilterForm(tlid TodoListID, filter *TodoListRevisionsFilter) g.Node {
+	changesOption := func(changes RevisionChanges, text string) g.Node {
+		return Option(Value(string(changes)), g.Text(text), g.If(filter.Changes == changes, Selected()))
+	}
+	return FormEl(Method("get"), Action(tlid.HrefTo("revisions")),
+		g.If(filter.AsOf != nil, Input(Type("hidden"), Name("as_of"), Value(filter.AsOf.Format(time.RFC3339Nano)))),
+		Label(For("changes"), g.Text("Show:")),
+		Select(Name("changes"),
+			changesOption(RevisionChangesAll, "All revisions"),
+			changesOption(RevisionChangesTodos, "Revisions changing todos"),
+			changesOption(RevisionChangesName, "Revisions changing the name")),
+		Label(For("from"), g.Text("From:")),
+		Input(Type("datetime-local"), Name("from"), Step("1"), Value(fmtTimeInput(filter.From))),
+		Label(For("to"), g.Text("To:")),
+		Input(Type("datetime-local"), Name("to"), Step("1"), Value(fmtTimeInput(filter.To))),
+		Button(g.Text("Filter")))
+}
+
+// todoListTombstoneRow marks that the list was deleted when the revision ended.
+func todoListTombstoneRow(tlhb TodoListRevisionBase, canRestore bool) g.Node {
+	return Tr(
+		Td(),
+		Td(Strong(g.Text("deleted"))),
+		Td(g.Text(fmtTime(derefTime(tlhb.SysUpper)))),
+		Td(),
+		Td(),
+		Td(g.If(canRestore, A(Href(tlhb.HistoryID.HrefTo("restore")), g.Text("restore")))))
+}
+
+func todoListRevisionRow(tlid TodoListID, rev TodoListRevisionSummary, asOf *time.Time) g.Node {
 	sysUpper := ""
-	if tlhb.SysUpper != nil {
-		sysUpper = fmtTime(*tlhb.SysUpper)
+	if rev.SysUpper != nil {
+		sysUpper = fmtTime(*rev.SysUpper)
+	}
+
+	var changes g.Node
+	if rev.PrevHistoryID != nil {
+		changes = A(Href(todoListDiffHref(tlid, *rev.PrevHistoryID, rev.HistoryID)), g.Text("changes"))
+	}
+
+	var event g.Node
+	switch {
+	case rev.RestoredFromRevision != nil:
+		event = g.Group([]g.Node{g.Text("restored "),
+			A(Href(withAsOf(tlid.RevisionHref(*rev.RestoredFromRevision), asOf)),
+				g.Text(revisionLabel(*rev.RestoredFromRevision)))})
+	case rev.RestoredFrom != nil:
+		// the restored revision has been purged.
+		event = g.Text("restored")
+	case rev.PrevHistoryID == nil:
+		event = g.Text("created")
+	case rev.AfterDeletion:
+		// restored before we kept track of what was restored.
+		event = g.Text("restored")
+	case rev.NameChanged && rev.TodosChanged:
+		event = g.Text("renamed, todos changed")
+	case rev.NameChanged:
+		event = g.Text("renamed")
+	case rev.TodosChanged:
+		event = g.Text("todos changed")
+	default:
+		event = g.Text("updated")
 	}
 
 	return Tr(
-		Td(A(Href(tlhb.HistoryID.Href()), g.Text("#"+strconv.Itoa(versionID)))),
-		Td(g.Text(fmtTime(tlhb.SysLower))),
-		Td(g.Text(sysUpper)))
+		Td(A(Href(withAsOf(tlid.RevisionHref(rev.Revision), asOf)), g.Text(revisionLabel(rev.Revision)))),
+		Td(event),
+		Td(g.Text(fmtTime(rev.SysLower))),
+		Td(g.Text(sysUpper)),
+		Td(changeSetLink(rev.ChangeSetID, fmtActor(rev.ChangedBy))),
+		Td(changes))
+}
+
+func revisionLabel(revision int) string {
+	return "#" + strconv.Itoa(revision)
+}
+
+func todoListDiffHref(tlid TodoListID, from, to TodoListHistoryID) string {
+	return tlid.HrefTo("diff") + "?from=" + from.String() + "&to=" + to.String()
+}
+
+// todoListDiffFromQuery reads the diff parameters shared by the diff page and
+// the diff API.
+func todoListDiffFromQuery(ctx *Context) (*TodoListDiff, error) {
+	var tlid TodoListID
+	err := tlid.Parse(ctx.Param("tlid"))
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to TodoListHistoryID
+	err = from.Parse(ctx.Query("from"))
+	if err != nil {
+		return nil, fmt.Errorf("bad from parameter: %w", err)
+	}
+	err = to.Parse(ctx.Query("to"))
+	if err != nil {
+		return nil, fmt.Errorf("bad to parameter: %w", err)
+	}
+
+	return GetTodoListRevisionDiff(ctx.Tx, tlid, from, to)
+}
+
+func getTodoListDiffAPIHandler(ctx *Context) (any, error) {
+	return todoListDiffFromQuery(ctx)
+}
+
+func getTodoListDiffHandler(ctx *Context) (g.Node, error) {
+	diff, err := todoListDiffFromQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageNode("Changes in "+diff.NewName,
+		[]g.Node{
+			H1(g.Text("Changes in " + diff.NewName)),
+			P(g.Text("From "), A(Href(diff.From.HistoryID.Href()),
+				g.Text(revisionLabel(diff.From.Revision)+" at "+fmtTime(diff.From.SysLower))),
+				g.Text(" to "), A(Href(diff.To.HistoryID.Href()),
+					g.Text(revisionLabel(diff.To.Revision)+" at "+fmtTime(diff.To.SysLower)))),
+			todoListDiffNode(diff),
+		},
+	), nil
+}
+
+func todoListDiffNode(diff *TodoListDiff) g.Node {
+	if diff.Empty() {
+		return P(g.Text("No changes."))
+	}
+	return g.Group([]g.Node{
+		g.If(diff.ListRenamed(), P(g.Text("List renamed from "), Em(g.Text(diff.OldName)),
+			g.Text(" to "), Em(g.Text(diff.NewName)))),
+		todoDiffSection("Added", diff.Added),
+		todoDiffSection("Removed", diff.Removed),
+		g.If(len(diff.Renamed) != 0, g.Group([]g.Node{
+			H3(g.Text("Renamed")),
+			Ul(g.Map(diff.Renamed, func(r TodoRename) g.Node {
+				return Li(S(g.Text(r.OldDescription)), g.Text(" → "+r.Todo.Description))
+			})...),
+		})),
+		todoDiffSection("Completed", diff.Completed),
+		todoDiffSection("Reactivated", diff.Reactivated),
+	})
+}
+
+func todoDiffSection(title string, todos Todos) g.Node {
+	return g.If(len(todos) != 0, g.Group([]g.Node{
+		H3(g.Text(title)),
+		Ul(g.Map(todos, func(todo Todo) g.Node {
+			return Li(g.Text(todo.Description))
+		})...),
+	}))
 }
 
 func completeTodoHandler(ctx *Context) error {
@@ -218,7 +569,12 @@ func completeTodoHandler(ctx *Context) error {
 		return err
 	}
 
-	err = SetTodoCompleted(ctx.Tx, tid, true)
+	validFrom, err := parseValidFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = SetTodoCompleted(ctx.Tx, tid, true, validFrom)
 	if err != nil {
 		return err
 	}
@@ -239,7 +595,12 @@ func reactivateTodoHandler(ctx *Context) error {
 		return err
 	}
 
-	err = SetTodoCompleted(ctx.Tx, tid, false)
+	validFrom, err := parseValidFrom(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = SetTodoCompleted(ctx.Tx, tid, false, validFrom)
 	if err != nil {
 		return err
 	}
@@ -253,6 +614,20 @@ func reactivateTodoHandler(ctx *Context) error {
 	return nil
 }
 
+// parseValidFrom returns when a change really happened, if the user has
+// backdated it.
+func parseValidFrom(ctx *Context) (*time.Time, error) {
+	str := strings.TrimSpace(ctx.PostForm("valid_from"))
+	if str == "" {
+		return nil, nil
+	}
+	validFrom, err := parseTimeParam(str)
+	if err != nil {
+		return nil, fmt.Errorf("bad valid_from parameter: %w", err)
+	}
+	return &validFrom, nil
+}
+
 func deleteTodoHandler(ctx *Context) error {
 	var tid TodoID
 	err := tid.Parse(ctx.Param("tid"))
@@ -274,6 +649,67 @@ func deleteTodoHandler(ctx *Context) error {
 	return nil
 }
 
+func getTodoHistoryHandler(ctx *Context) (g.Node, error) {
+	var tid TodoID
+	err := tid.Parse(ctx.Param("tid"))
+	if err != nil {
+		return nil, err
+	}
+
+	asOf, err := parseAsOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	trs, err := GetTodoRevisions(ctx.Tx, tid, asOf)
+	if err != nil {
+		return nil, err
+	}
+	if len(trs) == 0 {
+		return nil, fmt.Errorf("no revisions for todo with ID %s", tid)
+	}
+	latest := trs[0]
+
+	// Todos can only be restored into a list that still exists, and as usual
+	// nothing can be restored when browsing the past.
+	var current *TodoList
+	if asOf == nil {
+		current, err = GetTodoListByID(ctx.Tx, latest.ListID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+	revRenderer := newTodoRevisionRenderer(current)
+
+	return pageNode("History of "+latest.Description,
+		[]g.Node{
+			H1(g.Text("History of " + latest.Description)),
+			g.If(asOf != nil, asOfBanner(derefTime(asOf), tid.HrefTo("history"))),
+			P(A(Href(withAsOf(latest.ListID.Href(), asOf)), g.Text("[todo list]")),
+				g.If(asOf == nil && current == nil, Em(g.Text(" The todo list is deleted, restore it to restore this todo.")))),
+			table([]string{"Valid from", "Valid to", "Description", "Completed", "Really happened", "Changed by", ""},
+				g.Map(trs, revRenderer.historyRow)),
+			g.If(asOf == nil && latest.SysUpper == nil, backdateTodoForm(latest.Todo)),
+		},
+	), nil
+}
+
+// backdateTodoForm records that the todo was completed or reactivated at some
+// point in the past.
+func backdateTodoForm(todo Todo) g.Node {
+	action, text := "complete", "Mark as completed"
+	if todo.Completed {
+		action, text = "reactivate", "Mark as not completed"
+	}
+	return FormEl(Method("post"), Action(todo.ID.HrefTo(action)),
+		H3(g.Text("Record a change that happened earlier")),
+		Label(For("valid_from"), g.Text("It really happened at:")),
+		Input(Type("datetime-local"), Name("valid_from"), Step("1"), Required()),
+		Label(For("message"), g.Text("Note (optional):")),
+		Input(Type("text"), Name("message")),
+		Button(g.Text(text)))
+}
+
 func getTodoListRevisionHandler(ctx *Context) (g.Node, error) {
 	var tlhid TodoListHistoryID
 	err := tlhid.Parse(ctx.Param("tlhid"))
@@ -281,28 +717,81 @@ func getTodoListRevisionHandler(ctx *Context) (g.Node, error) {
 		return nil, err
 	}
 
+	asOf, err := parseAsOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	todoListRev, err := GetTodoListRevisionByID(ctx.Tx, tlhid)
 	if err != nil {
 		return nil, err
 	}
+	return todoListRevisionPage(ctx, todoListRev, asOf)
+}
 
-	current, err := GetTodoListByID(ctx.Tx, todoListRev.ID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+func getTodoListRevisionByNumberHandler(ctx *Context) (g.Node, error) {
+	var tlid TodoListID
+	err := tlid.Parse(ctx.Param("tlid"))
+	if err != nil {
+		return nil, err
+	}
+	revision, err := strconv.Atoi(ctx.Param("n"))
+	if err != nil {
+		return nil, fmt.Errorf("bad revision number: %w", err)
+	}
+
+	asOf, err := parseAsOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	todoListRev, err := GetTodoListRevisionByNumber(ctx.Tx, tlid, revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("todo list %s has no revision #%d", tlid, revision)
+	}
+	if err != nil {
 		return nil, err
 	}
+	return todoListRevisionPage(ctx, todoListRev, asOf)
+}
+
+func todoListRevisionPage(ctx *Context, todoListRev *TodoListRevision, asOf *time.Time) (g.Node, error) {
+	tlhid := todoListRev.HistoryID
+	if asOf != nil && todoListRev.SysLower.After(*asOf) {
+		return nil, fmt.Errorf("revision %s did not exist as of %s", revisionLabel(todoListRev.Revision), fmtTime(*asOf))
+	}
+
+	var err error
+
+	// When browsing the past, the page is read-only: There is nothing to
+	// compare against, and nothing can be restored.
+	var current *TodoList
+	if asOf == nil {
+		current, err = GetTodoListByID(ctx.Tx, todoListRev.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
 
 	completed := todoListRev.Todos.FilterByCompleted(true)
 	unfinished := todoListRev.Todos.FilterByCompleted(false)
 
 	revRenderer := newTodoRevisionRenderer(current)
 
-	return pageNode("Revision of "+todoListRev.Name,
+	return pageNode("Revision "+revisionLabel(todoListRev.Revision)+" of "+todoListRev.Name,
 		[]g.Node{
 			H1(g.Text(todoListRev.Name)),
-			P(A(Href(todoListRev.ID.Href()), g.Text("[current version]")), g.Text(" "),
-				A(Href(todoListRev.ID.HrefTo("revisions")), g.Text("[list revisions]")), g.Text(" "),
-				g.If(!revRenderer.equalTodos(todoListRev.Todos),
-					postButton(todoListRev.HistoryID.HrefTo("restore"), "Restore list to this revision"))),
+			g.If(asOf != nil, asOfBanner(derefTime(asOf), todoListRev.ID.RevisionHref(todoListRev.Revision))),
+			P(g.Text("Revision "+revisionLabel(todoListRev.Revision)+" made by "), changeSetLink(todoListRev.ChangeSetID, fmtActor(todoListRev.ChangedBy)),
+				g.Text(" at "+fmtTime(todoListRev.SysLower)+".")),
+			P(A(Href(withAsOf(todoListRev.ID.Href(), asOf)), g.Text("[current version]")), g.Text(" "),
+				A(Href(withAsOf(todoListRev.ID.HrefTo("revisions"), asOf)), g.Text("[list revisions]")), g.Text(" "),
+				g.If(asOf == nil && !revRenderer.equalTodos(todoListRev.Todos),
+					A(Class("inline-form"), Href(todoListRev.HistoryID.HrefTo("restore")), g.Text("Restore list to this revision"))),
+				g.If(asOf == nil && current != nil && !revRenderer.equalTodos(todoListRev.Todos),
+					A(Class("inline-form"), Href(todoListRev.HistoryID.HrefTo("merge")), g.Text("Merge into current list"))),
+				g.If(asOf == nil,
+					postButton(todoListRev.HistoryID.HrefTo("fork"), "Fork into a new list"))),
 			g.If(len(unfinished) != 0, g.Group([]g.Node{
 				H3(g.Text("Todos")),
 				Table(TBody(g.Map(unfinished, revRenderer.unfinishedRow)...)),
@@ -311,10 +800,45 @@ func getTodoListRevisionHandler(ctx *Context) (g.Node, error) {
 				H3(g.Text("Completed")),
 				Table(TBody(g.Map(completed, revRenderer.completedRow)...)),
 			})),
+			g.If(revRenderer.anyRestorable(todoListRev.Todos),
+				FormEl(ID(restoreTodosFormID), Method("post"), Action(tlhid.HrefTo("restore-todos")),
+					Button(g.Text("Restore selected todos")))),
 		},
 	), nil
 }
 
+func restoreTodosHandler(ctx *Context) error {
+	var tlhid TodoListHistoryID
+	err := tlhid.Parse(ctx.Param("tlhid"))
+	if err != nil {
+		return err
+	}
+
+	thidStrs := ctx.PostFormArray("thid")
+	thids := make([]TodoHistoryID, len(thidStrs))
+	for i, str := range thidStrs {
+		err = thids[i].Parse(str)
+		if err != nil {
+			return err
+		}
+	}
+
+	tlr, err := GetTodoListRevisionByID(ctx.Tx, tlhid)
+	if err != nil {
+		return err
+	}
+	listID, err := RestoreTodosToRevisions(ctx.Tx, thids)
+	if err != nil {
+		return err
+	}
+	if *listID != tlr.ID {
+		return fmt.Errorf("the todos are not from todo list %s", tlr.ID)
+	}
+
+	ctx.Redirect(http.StatusSeeOther, tlhid.Href())
+	return nil
+}
+
 func newTodoRevisionRenderer(current *TodoList) todoRevisionRenderer {
 	trr := todoRevisionRenderer{
 		canRestoreTodos: current != nil,
@@ -359,21 +883,147 @@ func (trr todoRevisionRenderer) revisionIsStale(todo TodoRevision) bool {
 	return !ok || curTodo != todo.Todo
 }
 
+func (trr todoRevisionRenderer) canRestore(todo TodoRevision) bool {
+	return trr.canRestoreTodos && trr.revisionIsStale(todo)
+}
+
+func (trr todoRevisionRenderer) anyRestorable(todos TodoRevisions) bool {
+	for _, todo := range todos {
+		if trr.canRestore(todo) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTodosFormID is the form the restore checkboxes belong to. The
+// checkboxes are spread over several tables, and forms can't be nested, so they
+// refer to it by ID.
+const restoreTodosFormID = "restore-todos"
+
+func (trr todoRevisionRenderer) restoreCheckbox(todo TodoRevision) g.Node {
+	return g.If(trr.canRestore(todo),
+		Input(Type("checkbox"), Name("thid"), Value(todo.HistoryID.String()),
+			FormAttr(restoreTodosFormID)))
+}
+
 func (trr todoRevisionRenderer) unfinishedRow(todo TodoRevision) g.Node {
 	return Tr(
-		Td(g.Text(todo.Description)),
-		Td(g.If(trr.canRestoreTodos && trr.revisionIsStale(todo),
+		g.If(trr.canRestoreTodos, Td(trr.restoreCheckbox(todo))),
+		Td(A(Href(todo.ID.HrefTo("history")), g.Text(todo.Description))),
+		Td(g.If(trr.canRestore(todo),
 			postButton(todo.HistoryID.HrefTo("restore"), "Restore Todo to this state"))),
 	)
 }
 func (trr todoRevisionRenderer) completedRow(todo TodoRevision) g.Node {
 	return Tr(
-		Td(S(g.Text(todo.Description))),
-		Td(g.If(trr.canRestoreTodos && trr.revisionIsStale(todo),
+		g.If(trr.canRestoreTodos, Td(trr.restoreCheckbox(todo))),
+		Td(A(Href(todo.ID.HrefTo("history")), S(g.Text(todo.Description)))),
+		Td(g.If(trr.canRestore(todo),
+			postButton(todo.HistoryID.HrefTo("restore"), "Restore Todo to this state"))),
+	)
+}
+
+func fmtValidTime(vt ValidTime) string {
+	if vt.To.IsZero() {
+		return fmtTime(vt.From)
+	}
+	return fmtTime(vt.From) + " until " + fmtTime(vt.To)
+}
+
+func (trr todoRevisionRenderer) historyRow(todo TodoRevision) g.Node {
+	completed := "no"
+	if todo.Completed {
+		completed = "yes"
+	}
+	sysUpper := ""
+	if todo.SysUpper != nil {
+		sysUpper = fmtTime(*todo.SysUpper)
+	}
+	return Tr(
+		Td(g.Text(fmtTime(todo.SysLower))),
+		Td(g.Text(sysUpper)),
+		Td(g.Text(todo.Description)),
+		Td(g.Text(completed)),
+		Td(g.Text(fmtValidTime(todo.ValidTime))),
+		Td(changeSetLink(todo.ChangeSetID, fmtActor(todo.ChangedBy))),
+		Td(g.If(trr.canRestore(todo),
 			postButton(todo.HistoryID.HrefTo("restore"), "Restore Todo to this state"))),
 	)
 }
 
+func getRestoreTodoListRevisionHandler(ctx *Context) (g.Node, error) {
+	var tlhid TodoListHistoryID
+	err := tlhid.Parse(ctx.Param("tlhid"))
+	if err != nil {
+		return nil, err
+	}
+
+	restore, err := PreviewTodoListRestore(ctx.Tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+	changes := &restore.Changes
+
+	var summary g.Node
+	switch {
+	case restore.ListDeleted:
+		summary = P(g.Text("The list "), Em(g.Text(changes.NewName)),
+			g.Text(" is deleted, and will be brought back."))
+	case changes.Empty():
+		summary = P(g.Text("The list already looks like this revision, restoring it changes nothing."))
+	default:
+		summary = P(g.Text("Restoring will make these changes to the list:"))
+	}
+
+	return pageNode("Restore "+changes.NewName,
+		[]g.Node{
+			H1(g.Text("Restore " + changes.NewName)),
+			P(g.Text("Restoring the list to "),
+				A(Href(tlhid.Href()), g.Text("revision "+revisionLabel(restore.Revision.Revision)+
+					" from "+fmtTime(restore.Revision.SysLower))), g.Text(".")),
+			summary,
+			g.If(!changes.Empty(), g.Group([]g.Node{
+				g.If(changes.ListRenamed(), P(g.Text("The list is renamed from "), Em(g.Text(changes.OldName)),
+					g.Text(" to "), Em(g.Text(changes.NewName)))),
+				todoDiffSection("Recreated", changes.Added),
+				todoDiffSection("Deleted", changes.Removed),
+				g.If(len(changes.Renamed) != 0, g.Group([]g.Node{
+					H3(g.Text("Renamed")),
+					Ul(g.Map(changes.Renamed, func(r TodoRename) g.Node {
+						return Li(S(g.Text(r.OldDescription)), g.Text(" → "+r.Todo.Description))
+					})...),
+				})),
+				todoDiffSection("Marked as completed", changes.Completed),
+				todoDiffSection("Reactivated", changes.Reactivated),
+			})),
+			FormEl(Method("post"), Action(tlhid.HrefTo("restore")),
+				Label(For("message"), g.Text("Note (optional):")),
+				Input(Type("text"), Name("message")),
+				Button(g.Text("Restore list to this revision"))),
+		},
+	), nil
+}
+
+// restoreTodoListRevisionAPIHandler restores the revision and returns what
+// changed. With dry_run=true, nothing is restored.
+func restoreTodoListRevisionAPIHandler(ctx *Context) (any, error) {
+	var tlhid TodoListHistoryID
+	err := tlhid.Parse(ctx.Param("tlhid"))
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("bad dry_run parameter: %w", err)
+	}
+	if dryRun {
+		return PreviewTodoListRestore(ctx.Tx, tlhid)
+	}
+	return RestoreTodoListToRevisionWithChanges(ctx.Tx, tlhid)
+}
+
 func restoreTodoListRevisionHandler(ctx *Context) error {
 	var tlhid TodoListHistoryID
 	err := tlhid.Parse(ctx.Param("tlhid"))
@@ -390,6 +1040,128 @@ func restoreTodoListRevisionHandler(ctx *Context) error {
 	return nil
 }
 
+func forkTodoListRevisionHandler(ctx *Context) error {
+	var tlhid TodoListHistoryID
+	err := tlhid.Parse(ctx.Param("tlhid"))
+	if err != nil {
+		return err
+	}
+
+	listID, err := ForkTodoListRevision(ctx.Tx, tlhid)
+	if err != nil {
+		return err
+	}
+
+	ctx.Redirect(http.StatusSeeOther, listID.Href())
+	return nil
+}
+
+func getMergeTodoListRevisionHandler(ctx *Context) (g.Node, error) {
+	var tlhid TodoListHistoryID
+	err := tlhid.Parse(ctx.Param("tlhid"))
+	if err != nil {
+		return nil, err
+	}
+
+	merge, err := PlanTodoListMerge(ctx.Tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageNode("Merge into "+merge.Current.Name,
+		[]g.Node{
+			H1(g.Text("Merge into " + merge.Current.Name)),
+			P(g.Text("Merging "), A(Href(tlhid.Href()), g.Text("revision "+revisionLabel(merge.Revision.Revision)+
+				" from "+fmtTime(merge.Revision.SysLower))),
+				g.Text(" into the "), A(Href(merge.Current.ID.Href()), g.Text("current list")), g.Text(".")),
+			FormEl(Method("post"), Action(tlhid.HrefTo("merge")),
+				mergeBaseNote(merge.Base),
+				g.If(merge.NameConflict(), g.Group([]g.Node{
+					H3(g.Text("Name")),
+					mergeChoice("name", merge.Current.Name, merge.Revision.Name),
+				})),
+				g.If(merge.TakesRevisionName(), g.Group([]g.Node{
+					H3(g.Text("Name")),
+					P(g.Text("Renamed to " + merge.Revision.Name + ", only the revision changed it.")),
+				})),
+				todoDiffSection("Kept, added since the revision", merge.Kept),
+				todoDiffSection("Added back, deleted since the revision", merge.Readded.Todos()),
+				todoDiffSection("Taken from the revision, only changed there", merge.Taken.Todos()),
+				g.If(len(merge.Conflicts) != 0, g.Group([]g.Node{
+					H3(g.Text("Conflicts, changed on both sides")),
+					g.Group(g.Map(merge.Conflicts, func(conflict TodoMergeConflict) g.Node {
+						return mergeChoice("todo-"+conflict.Current.ID.String(),
+							fmtTodo(conflict.Current), fmtTodo(conflict.Revision.Todo))
+					})),
+				})),
+				Label(For("message"), g.Text("Note (optional):")),
+				Input(Type("text"), Name("message")),
+				Button(g.Text("Merge"))),
+		},
+	), nil
+}
+
+func mergeBaseNote(base *TodoListRevision) g.Node {
+	if base == nil {
+		return P(g.Text("The revision and the current list have no revision in common, so every todo that differs is a conflict."))
+	}
+	return P(g.Text("Changes are compared to "), A(Href(base.HistoryID.Href()),
+		g.Text("revision "+revisionLabel(base.Revision)+" from "+fmtTime(base.SysLower))),
+		g.Text(", the last revision both sides share."))
+}
+
+// mergeChoice lets the user pick between the current version and the
+// revision's version, keeping the current one by default.
+func mergeChoice(name, current, revision string) g.Node {
+	return P(
+		Label(Input(Type("radio"), Name(name), Value("current"), g.Attr("checked")),
+			g.Text(" Keep current: "+current)),
+		Br(),
+		Label(Input(Type("radio"), Name(name), Value("revision")),
+			g.Text(" Take revision: "+revision)),
+	)
+}
+
+func fmtTodo(todo Todo) string {
+	if todo.Completed {
+		return todo.Description + " (completed)"
+	}
+	return todo.Description
+}
+
+func mergeTodoListRevisionHandler(ctx *Context) error {
+	var tlhid TodoListHistoryID
+	err := tlhid.Parse(ctx.Param("tlhid"))
+	if err != nil {
+		return err
+	}
+
+	res := TodoListMergeResolution{
+		TakeRevisionName: ctx.PostForm("name") == "revision",
+		TakeRevision:     map[TodoID]bool{},
+	}
+	for key, vals := range ctx.Request.PostForm {
+		idStr, ok := strings.CutPrefix(key, "todo-")
+		if !ok || len(vals) == 0 || vals[0] != "revision" {
+			continue
+		}
+		var tid TodoID
+		err = tid.Parse(idStr)
+		if err != nil {
+			return err
+		}
+		res.TakeRevision[tid] = true
+	}
+
+	listID, err := MergeTodoListRevision(ctx.Tx, tlhid, res)
+	if err != nil {
+		return err
+	}
+
+	ctx.Redirect(http.StatusSeeOther, listID.Href())
+	return nil
+}
+
 func restoreTodoRevisionHandler(ctx *Context) error {
 	var thid TodoHistoryID
 	err := thid.Parse(ctx.Param("thid"))
@@ -408,6 +1180,320 @@ func restoreTodoRevisionHandler(ctx *Context) error {
 	return nil
 }
 
+func getTrashHandler(ctx *Context) (g.Node, error) {
+	tlrs, err := GetDeletedTodoLists(ctx.Tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageNode("Trash",
+		[]g.Node{
+			H1(g.Text("Trash")),
+			g.If(len(tlrs) == 0, P(g.Text("No deleted todo lists."))),
+			g.If(len(tlrs) != 0, table([]string{"Name", "Deleted", "Last contents", ""},
+				g.Map(tlrs, trashRow))),
+		},
+	), nil
+}
+
+func trashRow(tlr TodoListRevision) g.Node {
+	return Tr(
+		Td(A(Href(tlr.HistoryID.Href()), g.Text(tlr.Name))),
+		Td(g.Text(fmtTime(derefTime(tlr.SysUpper)))),
+		Td(g.If(len(tlr.Todos) == 0, Em(g.Text("no todos"))),
+			Ul(g.Map(tlr.Todos, func(todo TodoRevision) g.Node {
+				if todo.Completed {
+					return Li(S(g.Text(todo.Description)))
+				}
+				return Li(g.Text(todo.Description))
+			})...)),
+		Td(A(Class("inline-form"), Href(tlr.HistoryID.HrefTo("restore")), g.Text("Restore")),
+			FormEl(Class("inline-form"), Method("post"), Action("/trash/"+tlr.ID.String()+"/purge"),
+				g.Attr("onsubmit", "return confirm('Permanently delete all history of this list?')"),
+				Button(g.Text("Purge")))),
+	)
+}
+
+func purgeTodoListHandler(ctx *Context) error {
+	var tlid TodoListID
+	err := tlid.Parse(ctx.Param("tlid"))
+	if err != nil {
+		return err
+	}
+
+	err = PurgeTodoList(ctx.Tx, tlid)
+	if err != nil {
+		return err
+	}
+
+	ctx.Redirect(http.StatusSeeOther, "/trash")
+	return nil
+}
+
+const changeSetsPerPage = 50
+
+func getChangeSetsHandler(ctx *Context) (g.Node, error) {
+	var before *ChangeSetCursor
+	if str := ctx.Query("before"); str != "" {
+		at, err := parseTimeParam(str)
+		if err != nil {
+			return nil, fmt.Errorf("bad before parameter: %w", err)
+		}
+		var csid ChangeSetID
+		err = csid.Parse(ctx.Query("before_id"))
+		if err != nil {
+			return nil, fmt.Errorf("bad before_id parameter: %w", err)
+		}
+		before = &ChangeSetCursor{CreatedAt: at, ID: csid}
+	}
+
+	css, err := GetChangeSets(ctx.Tx, before, changeSetsPerPage)
+	if err != nil {
+		return nil, err
+	}
+
+	var older g.Node
+	if len(css) == changeSetsPerPage {
+		next := css[len(css)-1].Cursor()
+		query := url.Values{}
+		query.Set("before", next.CreatedAt.Format(time.RFC3339Nano))
+		query.Set("before_id", next.ID.String())
+		older = P(A(Href("/change-sets?"+query.Encode()), g.Text("Older change sets")))
+	}
+
+	return pageNode("Change Sets",
+		[]g.Node{
+			H1(g.Text("Change Sets")),
+			table([]string{"When", "Who", "Route", "Message", "List revisions", "Todo revisions"},
+				g.Map(css, changeSetRow)),
+			older,
+		},
+	), nil
+}
+
+func changeSetRow(cs ChangeSetSummary) g.Node {
+	return Tr(
+		Td(A(Href(cs.ID.Href()), g.Text(fmtTime(cs.CreatedAt)))),
+		Td(g.Text(cs.Actor)),
+		Td(g.Text(derefString(cs.Route))),
+		Td(g.Text(derefString(cs.Message))),
+		Td(g.Text(strconv.Itoa(cs.TodoListRevisions))),
+		Td(g.Text(strconv.Itoa(cs.TodoRevisions))),
+	)
+}
+
+func getChangeSetHandler(ctx *Context) (g.Node, error) {
+	var csid ChangeSetID
+	err := csid.Parse(ctx.Param("csid"))
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := GetChangeSetByID(ctx.Tx, csid)
+	if err != nil {
+		return nil, err
+	}
+
+	revs, err := GetChangeSetRevisions(ctx.Tx, csid)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageNode("Change Set "+csid.String(),
+		[]g.Node{
+			H1(g.Text("Change Set " + csid.String())),
+			P(g.Text("Made by "+cs.Actor+" at "+fmtTime(cs.CreatedAt)),
+				g.If(cs.Route != nil, g.Text(" through "+derefString(cs.Route))), g.Text(".")),
+			g.If(cs.Message != nil, BlockQuote(g.Text(derefString(cs.Message)))),
+			g.If(len(revs.TodoLists) != 0, g.Group([]g.Node{
+				H3(g.Text("Todo list revisions")),
+				Ul(g.Map(revs.TodoLists, func(tlr TodoListRevision) g.Node {
+					return Li(A(Href(tlr.HistoryID.Href()), g.Text(tlr.Name+" "+revisionLabel(tlr.Revision))))
+				})...),
+			})),
+			g.If(len(revs.Todos) != 0, g.Group([]g.Node{
+				H3(g.Text("Todo revisions")),
+				Ul(g.Map(revs.Todos, func(tr TodoRevision) g.Node {
+					if tr.Completed {
+						return Li(S(g.Text(tr.Description)))
+					}
+					return Li(g.Text(tr.Description))
+				})...),
+			})),
+		},
+	), nil
+}
+
+const activitiesPerPage = 50
+
+// activityFilterFromQuery reads the filter parameters shared by the activity
+// page and the activity API.
+func activityFilterFromQuery(ctx *Context) (*ActivityFilter, error) {
+	filter := ActivityFilter{
+		Limit: activitiesPerPage,
+	}
+	if str := ctx.Query("list"); str != "" {
+		var tlid TodoListID
+		err := tlid.Parse(str)
+		if err != nil {
+			return nil, fmt.Errorf("bad list parameter: %w", err)
+		}
+		filter.ListID = &tlid
+	}
+	var err error
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		*param.dest, err = parseOptionalTimeParam(ctx, param.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if str := ctx.Query("before"); str != "" {
+		at, err := parseTimeParam(str)
+		if err != nil {
+			return nil, fmt.Errorf("bad before parameter: %w", err)
+		}
+		id, err := uuid.Parse(ctx.Query("before_id"))
+		if err != nil {
+			return nil, fmt.Errorf("bad before_id parameter: %w", err)
+		}
+		filter.Before = &ActivityCursor{At: at, ID: id}
+	}
+	return &filter, nil
+}
+
+// ActivityPage is a page of the activity feed. Next is set if there may be
+// more activities, and is passed in as before/before_id to get them.
+type ActivityPage struct {
+	Activities []Activity      `json:"activities"`
+	Next       *ActivityCursor `json:"next"`
+}
+
+func getActivityPage(ctx *Context) (*ActivityFilter, *ActivityPage, error) {
+	filter, err := activityFilterFromQuery(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	acts, err := GetActivity(ctx.Tx, *filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	page := ActivityPage{Activities: acts}
+	if len(acts) == filter.Limit {
+		next := acts[len(acts)-1].Cursor()
+		page.Next = &next
+	}
+	return filter, &page, nil
+}
+
+func getActivityAPIHandler(ctx *Context) (any, error) {
+	_, page, err := getActivityPage(ctx)
+	return page, err
+}
+
+func getActivityHandler(ctx *Context) (g.Node, error) {
+	filter, page, err := getActivityPage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tls, err := GetAllTodoLists(ctx.Tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var older g.Node
+	if page.Next != nil {
+		query := ctx.Request.URL.Query()
+		query.Set("before", page.Next.At.Format(time.RFC3339Nano))
+		query.Set("before_id", page.Next.ID.String())
+		older = P(A(Href("/activity?"+query.Encode()), g.Text("Older activity")))
+	}
+
+	return pageNode("Activity",
+		[]g.Node{
+			H1(g.Text("Activity")),
+			activityFilterForm(filter, tls),
+			g.If(len(page.Activities) == 0, P(g.Text("Nothing happened."))),
+			g.If(len(page.Activities) != 0, table([]string{"When", "Who", "What"},
+				g.Map(page.Activities, activityRow))),
+			older,
+		},
+	), nil
+}
+
+func activityFilterForm(filter *ActivityFilter, tls []TodoListBase) g.Node {
+	return FormEl(Method("get"), Action("/activity"),
+		Label(For("list"), g.Text("List:")),
+		Select(Name("list"),
+			Option(Value(""), g.Text("All lists")),
+			g.Group(g.Map(tls, func(tl TodoListBase) g.Node {
+				return Option(Value(tl.ID.String()), g.Text(tl.Name),
+					g.If(filter.ListID != nil && *filter.ListID == tl.ID, Selected()))
+			}))),
+		Label(For("from"), g.Text("From:")),
+		Input(Type("datetime-local"), Name("from"), Step("1"), Value(fmtTimeInput(filter.From))),
+		Label(For("to"), g.Text("To:")),
+		Input(Type("datetime-local"), Name("to"), Step("1"), Value(fmtTimeInput(filter.To))),
+		Button(g.Text("Filter")))
+}
+
+func activityRow(a Activity) g.Node {
+	// deletions have no history row of their own, so link to the last
+	// revision before it.
+	var listLink g.Node = A(Href(a.ListID.Href()), g.Text(a.ListName))
+	if a.Kind == ActivityListDeleted && a.ListHistoryID != nil {
+		listLink = A(Href(a.ListHistoryID.Href()), g.Text(a.ListName))
+	}
+	var todo g.Node
+	if a.TodoID != nil {
+		todo = A(Href(a.TodoID.HrefTo("history")), g.Text(derefString(a.Description)))
+	}
+
+	var what []g.Node
+	switch a.Kind {
+	case ActivityListCreated:
+		what = []g.Node{g.Text("list "), listLink, g.Text(" created")}
+	case ActivityListRenamed:
+		what = []g.Node{g.Text("list "), Em(g.Text(derefString(a.OldValue))), g.Text(" renamed to "), listLink}
+	case ActivityListDeleted:
+		what = []g.Node{g.Text("list "), listLink, g.Text(" deleted")}
+	case ActivityListRestored:
+		what = []g.Node{g.Text("list "), listLink, g.Text(" restored")}
+	case ActivityTodoCreated:
+		what = []g.Node{g.Text("todo "), todo, g.Text(" created in "), listLink}
+	case ActivityTodoRenamed:
+		what = []g.Node{g.Text("todo "), Em(g.Text(derefString(a.OldValue))), g.Text(" renamed to "), todo,
+			g.Text(" in "), listLink}
+	case ActivityTodoCompleted:
+		what = []g.Node{g.Text("todo "), todo, g.Text(" completed in "), listLink}
+	case ActivityTodoReactivated:
+		what = []g.Node{g.Text("todo "), todo, g.Text(" reactivated in "), listLink}
+	case ActivityTodoDeleted:
+		what = []g.Node{g.Text("todo "), todo, g.Text(" deleted from "), listLink}
+	case ActivityTodoRestored:
+		what = []g.Node{g.Text("todo "), todo, g.Text(" restored in "), listLink}
+	default:
+		what = []g.Node{g.Text(string(a.Kind))}
+	}
+
+	return Tr(
+		Td(g.Text(fmtTime(a.At))),
+		Td(changeSetLink(a.ChangeSetID, fmtActor(a.ChangedBy))),
+		Td(what...),
+	)
+}
+
+// changeSetLink links to the change set a revision was made in. History
+// recorded before change sets existed has none, so it's just text.
+func changeSetLink(csid *ChangeSetID, text string) g.Node {
+	if csid == nil {
+		return g.Text(text)
+	}
+	return A(Href(csid.Href()), g.Text(text))
+}
+
 func errorNode(err error) g.Node {
 	return pageNode("Error",
 		[]g.Node{
@@ -428,7 +1514,10 @@ func pageNode(title string, body []g.Node) g.Node {
 `)),
 		},
 		Body: []g.Node{
-			Nav(A(Href("/"), g.Text("Home"))),
+			Nav(A(Href("/"), g.Text("Home")), g.Text(" "),
+				A(Href("/change-sets"), g.Text("Change Sets")), g.Text(" "),
+				A(Href("/activity"), g.Text("Activity")), g.Text(" "),
+				A(Href("/trash"), g.Text("Trash"))),
 			g.Group(body),
 		},
 	})
@@ -439,10 +1528,103 @@ func postButton(url, text string) g.Node {
 		Method("post"), Action(url), Button(g.Text(text)))
 }
 
+// fmtActor formats who made a change. History recorded before we started
+// tracking this has no actor.
+func fmtActor(actor *string) string {
+	if actor == nil {
+		return "unknown"
+	}
+	return *actor
+}
+
 func fmtTime(t time.Time) string {
 	return t.Local().Format(time.DateTime)
 }
 
+// the formats we accept for timestamps in query parameters. datetime-local
+// inputs send the ones without a time zone, which we interpret as local time.
+var timeParamFormats = []string{
+	time.RFC3339Nano,
+	time.DateTime,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	time.DateOnly,
+}
+
+func parseTimeParam(str string) (time.Time, error) {
+	for _, layout := range timeParamFormats {
+		t, err := time.ParseInLocation(layout, str, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse %q as a timestamp", str)
+}
+
+// parseOptionalTimeParam returns the timestamp in the query parameter, or nil
+// if it's not set.
+func parseOptionalTimeParam(ctx *Context, name string) (*time.Time, error) {
+	str := ctx.Query(name)
+	if str == "" {
+		return nil, nil
+	}
+	t, err := parseTimeParam(str)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s parameter: %w", name, err)
+	}
+	return &t, nil
+}
+
+// fmtTimeInput formats t for a datetime-local input.
+func fmtTimeInput(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format("2006-01-02T15:04:05")
+}
+
+// parseAsOf returns the as_of query parameter, or nil if the page should show
+// the present.
+func parseAsOf(ctx *Context) (*time.Time, error) {
+	str := ctx.Query("as_of")
+	if str == "" {
+		return nil, nil
+	}
+	asOf, err := parseTimeParam(str)
+	if err != nil {
+		return nil, err
+	}
+	return &asOf, nil
+}
+
+// withAsOf makes the link stay at the same point in time, if we're browsing
+// the past.
+func withAsOf(href string, asOf *time.Time) string {
+	if asOf == nil {
+		return href
+	}
+	return href + "?as_of=" + url.QueryEscape(asOf.Format(time.RFC3339Nano))
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func derefTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func asOfBanner(asOf time.Time, presentHref string) g.Node {
+	return P(Strong(g.Text("Viewing as of "+fmtTime(asOf)+", read-only.")), g.Text(" "),
+		A(Href(presentHref), g.Text("[back to the present]")))
+}
+
 func table(headers []string, body []g.Node) g.Node {
 	return Table(
 		THead(Tr(g.Map(headers, func(s string) g.Node { return Th(g.Text(s)) })...)),
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Every history row has a hash of its contents, chained to the hash of the row
+// before it for the same id (see migrations/014_hash_chain.up.sql). The hashes
+// are computed by the database, but checked here, so that a broken or replaced
+// hash function in the database doesn't go unnoticed.
+//
+// Someone who can write to the database can still recompute a chain from the
+// row they edited and onwards. The head digest sums up the last hash of every
+// chain, so keeping it outside of the database at least tells a rewrite apart
+// from no changes at all. Compaction recomputes the chains it rewrites, so it
+// first checks that they aren't broken, and afterwards records the hash of the
+// head of each of them in history_chain_anchors (see
+// migrations/018_history_chain_anchors.up.sql). A later rewrite of anything up
+// to that head changes its hash. Detaching partitions records the hash of the
+// last row it takes away for each id, which the first row left follows, and
+// purging a chain (by purging a todo list, or by compaction dropping all of it)
+// records the hash of its last row.
+
+type HistoryChainProblemKind string
+
+const (
+	HistoryChainUnhashed  HistoryChainProblemKind = "unhashed"
+	HistoryChainAltered   HistoryChainProblemKind = "altered"
+	HistoryChainBroken    HistoryChainProblemKind = "broken"
+	HistoryChainTruncated HistoryChainProblemKind = "truncated"
+	HistoryChainRehashed  HistoryChainProblemKind = "rehashed"
+	HistoryChainMissing   HistoryChainProblemKind = "missing"
+)
+
+var historyChainProblemDescriptions = map[HistoryChainProblemKind]string{
+	HistoryChainUnhashed:  "has no hash",
+	HistoryChainAltered:   "does not match its hash, it has been altered",
+	HistoryChainBroken:    "does not follow the row before it, a row has been removed or altered",
+	HistoryChainTruncated: "is the first row, but follows another row, the rows before it have been removed",
+	HistoryChainRehashed:  "does not match the hash recorded when its chain was compacted, the chain has been rehashed",
+	HistoryChainMissing:   "was the head of its chain when it was compacted, but has been removed",
+}
+
+// HistoryChainProblem is a history row that is not where the hash chain says
+// it should be.
+type HistoryChainProblem struct {
+	Table     string
+	ID        string
+	HistoryID string
+	Kind      HistoryChainProblemKind
+}
+
+func (p HistoryChainProblem) String() string {
+	return fmt.Sprintf("%s row %s of %s %s", p.Table, p.HistoryID, p.ID, historyChainProblemDescriptions[p.Kind])
+}
+
+type HistoryChainReport struct {
+	Rows     int64
+	Problems []HistoryChainProblem
+	// HeadDigest is a hash of the last hash of every chain.
+	HeadDigest string
+}
+
+type historyChainRow struct {
+	ID        string `db:"id"`
+	HistoryID string `db:"history_id"`
+	PrevHash  []byte `db:"prev_hash"`
+	RowHash   []byte `db:"row_hash"`
+	Contents  string `db:"contents"`
+}
+
+// VerifyHistoryChains walks the hash chains of all history tables that have
+// them, and reports the rows that have been altered or removed.
+func VerifyHistoryChains(tx *Tx) (*HistoryChainReport, error) {
+	tables, err := getVersionedTables(tx)
+	if err != nil {
+		return nil, err
+	}
+	var report HistoryChainReport
+	heads := sha256.New()
+	for _, table := range tables {
+		history := table + "_history"
+		histCols, err := getTableColumns(tx, history)
+		if err != nil {
+			return nil, err
+		}
+		if !hasHashChain(histCols) {
+			continue
+		}
+		pk, err := getPrimaryKey(tx, table)
+		if err != nil {
+			return nil, err
+		}
+		if len(pk) != 1 {
+			return nil, fmt.Errorf("table %s must have a primary key of exactly one column, not %d", table, len(pk))
+		}
+		err = verifyHistoryChain(tx, table, pk[0], &report, func(head historyChainRow) {
+			fmt.Fprintf(heads, "%s %s %x\n", history, head.ID, head.RowHash)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	report.HeadDigest = hex.EncodeToString(heads.Sum(nil))
+	return &report, nil
+}
+
+func hasHashChain(histCols []tableColumn) bool {
+	for _, col := range histCols {
+		if col.Name == "row_hash" {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyHistoryChain(tx *Tx, table, idCol string, report *HistoryChainReport, head func(historyChainRow)) error {
+	history := table + "_history"
+	id := pq.QuoteIdentifier(idCol)
+
+	// the last rows of detached partitions, which the first rows left may
+	// follow.
+	var boundaries []historyChainRow
+	err := tx.Select(&boundaries, `
+SELECT a.id
+     , CAST(a.history_id AS text) AS history_id
+     , a.row_hash
+FROM history_chain_anchors a
+WHERE a.history_table = :table
+  AND a.reason = 'detached'`, QueryArgs{
+		"table": history,
+	})
+	if err != nil {
+		return err
+	}
+	detached := make(map[string]bool, len(boundaries))
+	for _, boundary := range boundaries {
+		detached[boundary.ID+" "+hex.EncodeToString(boundary.RowHash)] = true
+	}
+
+	// not through tx.Select, as the history tables can be too large to keep in
+	// memory.
+	rows, err := tx.tx.Queryx(`
+SELECT CAST(h.` + id + ` AS text) AS id
+     , CAST(h.history_id AS text) AS history_id
+     , h.prev_hash
+     , h.row_hash
+     , history_hash_contents(h) AS contents
+FROM ` + pq.QuoteIdentifier(history) + ` h
+ORDER BY h.` + id + `, h.systime`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var prev *historyChainRow
+	for rows.Next() {
+		var row historyChainRow
+		err = rows.StructScan(&row)
+		if err != nil {
+			return err
+		}
+		report.Rows++
+
+		first := prev == nil || prev.ID != row.ID
+		if first && prev != nil {
+			head(*prev)
+		}
+		problem := HistoryChainProblem{Table: history, ID: row.ID, HistoryID: row.HistoryID}
+		sum := sha256.Sum256([]byte(row.Contents))
+		switch {
+		case row.RowHash == nil:
+			problem.Kind = HistoryChainUnhashed
+		case first && row.PrevHash != nil && !detached[row.ID+" "+hex.EncodeToString(row.PrevHash)]:
+			problem.Kind = HistoryChainTruncated
+		case !first && !bytes.Equal(row.PrevHash, prev.RowHash):
+			problem.Kind = HistoryChainBroken
+		case !bytes.Equal(sum[:], row.RowHash):
+			problem.Kind = HistoryChainAltered
+		}
+		if problem.Kind != "" {
+			report.Problems = append(report.Problems, problem)
+		}
+		prev = &row
+	}
+	if prev != nil {
+		head(*prev)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	// the heads recorded by the latest compaction of each chain must be as they
+	// were, unless a partition has been detached or the chain has been purged
+	// since, taking them along.
+	var anchored []struct {
+		ID        string `db:"id"`
+		HistoryID string `db:"history_id"`
+		Missing   bool   `db:"missing"`
+	}
+	err = tx.Select(&anchored, `
+SELECT a.id
+     , CAST(a.history_id AS text) AS history_id
+     , h.history_id IS NULL AS missing
+FROM (SELECT DISTINCT ON (a.id) a.*
+      FROM history_chain_anchors a
+      WHERE a.history_table = :table
+        AND a.reason = 'compacted'
+      ORDER BY a.id, a.anchor_id DESC) a
+LEFT JOIN `+pq.QuoteIdentifier(history)+` h
+  ON h.history_id = a.history_id
+WHERE (h.history_id IS NOT NULL AND h.row_hash IS DISTINCT FROM a.row_hash)
+   OR (h.history_id IS NULL
+       AND NOT EXISTS (SELECT 1
+                       FROM history_chain_anchors d
+                       WHERE d.history_table = a.history_table
+                         AND d.id = a.id
+                         AND d.reason IN ('detached', 'purged')
+                         AND d.anchor_id > a.anchor_id))
+ORDER BY a.id`, QueryArgs{
+		"table": history,
+	})
+	if err != nil {
+		return err
+	}
+	for _, anchor := range anchored {
+		problem := HistoryChainProblem{Table: history, ID: anchor.ID, HistoryID: anchor.HistoryID, Kind: HistoryChainRehashed}
+		if anchor.Missing {
+			problem.Kind = HistoryChainMissing
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+	return nil
+}
+
+// anchorHistoryChains records the hash of the last row of every id in rows, a
+// table or subquery of rows from history taking args, as an anchor for reason.
+func anchorHistoryChains(tx *Tx, history, idCol, rows string, args QueryArgs, reason string) error {
+	id := pq.QuoteIdentifier(idCol)
+	anchorArgs := QueryArgs{
+		"table":  history,
+		"reason": reason,
+	}
+	for k, v := range args {
+		anchorArgs[k] = v
+	}
+	return tx.Exec(`
+INSERT INTO history_chain_anchors (history_table, id, history_id, row_hash, reason)
+SELECT DISTINCT ON (h.`+id+`) :table, CAST(h.`+id+` AS text), h.history_id, h.row_hash, :reason
+FROM `+rows+` h
+ORDER BY h.`+id+`, h.systime DESC`, anchorArgs)
+}
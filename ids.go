@@ -54,6 +54,16 @@ func (id *TodoListID) Parse(str string) error {
 	return (*idUtil)(id).fromStr("tl", str)
 }
 
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id TodoListID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *TodoListID) UnmarshalText(data []byte) error {
+	return id.Parse(string(data))
+}
+
 func (id TodoListID) Href() string {
 	return fmt.Sprintf("/todo-lists/%s", id)
 }
@@ -62,6 +72,11 @@ func (id TodoListID) HrefTo(action string) string {
 	return fmt.Sprintf("/todo-lists/%s/%s", id, action)
 }
 
+// RevisionHref links to a revision of the list by its revision number.
+func (id TodoListID) RevisionHref(revision int) string {
+	return fmt.Sprintf("/todo-lists/%s/revisions/%d", id, revision)
+}
+
 type TodoListHistoryID uuid.UUID
 
 // Value implements the sql.Valuer interface
@@ -83,6 +98,16 @@ func (id *TodoListHistoryID) Parse(str string) error {
 	return (*idUtil)(id).fromStr("tl_hist", str)
 }
 
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id TodoListHistoryID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *TodoListHistoryID) UnmarshalText(data []byte) error {
+	return id.Parse(string(data))
+}
+
 func (id TodoListHistoryID) Href() string {
 	return fmt.Sprintf("/todo-lists-history/%s", id)
 }
@@ -112,6 +137,16 @@ func (id *TodoID) Parse(str string) error {
 	return (*idUtil)(id).fromStr("todo", str)
 }
 
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id TodoID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *TodoID) UnmarshalText(data []byte) error {
+	return id.Parse(string(data))
+}
+
 func (id TodoID) Href() string {
 	return fmt.Sprintf("/todos/%s", id)
 }
@@ -141,6 +176,16 @@ func (id *TodoHistoryID) Parse(str string) error {
 	return (*idUtil)(id).fromStr("todo_hist", str)
 }
 
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id TodoHistoryID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *TodoHistoryID) UnmarshalText(data []byte) error {
+	return id.Parse(string(data))
+}
+
 func (id TodoHistoryID) Href(action string) string {
 	return fmt.Sprintf("/todos-history/%s", id)
 }
@@ -148,3 +193,38 @@ func (id TodoHistoryID) Href(action string) string {
 func (id TodoHistoryID) HrefTo(action string) string {
 	return fmt.Sprintf("/todos-history/%s/%s", id, action)
 }
+
+type ChangeSetID uuid.UUID
+
+// Value implements the sql.Valuer interface
+func (id ChangeSetID) Value() (driver.Value, error) {
+	return uuid.UUID(id).Value()
+}
+
+// Scan implements the sql.Scanner interface
+func (id *ChangeSetID) Scan(value interface{}) error {
+	return (*uuid.UUID)(id).Scan(value)
+}
+
+// String implements the Stringer interface.
+func (id ChangeSetID) String() string {
+	return idUtil(id).str("cs")
+}
+
+func (id *ChangeSetID) Parse(str string) error {
+	return (*idUtil)(id).fromStr("cs", str)
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (id ChangeSetID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (id *ChangeSetID) UnmarshalText(data []byte) error {
+	return id.Parse(string(data))
+}
+
+func (id ChangeSetID) Href() string {
+	return fmt.Sprintf("/change-sets/%s", id)
+}
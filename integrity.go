@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+// The triggers keep the history tables in line with their tables, but a bad
+// migration or someone fiddling around in psql can break that. The integrity
+// check looks for the invariants the triggers maintain:
+//
+//   - every live row has exactly one open-ended history row,
+//   - deleted rows have no open-ended history row,
+//   - the history rows of a row don't overlap,
+//   - the open-ended history row matches the live row, and
+//   - the history rows of a row follow each other without gaps, unless the
+//     row after the gap was put back by a restore.
+//
+// A deletion followed by a restore leaves a gap. Restores of a whole list are
+// recorded in todo_list_restore_revisions, and everything else putting deleted
+// rows back runs in a change set for one of the restoreRoutes.
+
+type HistoryProblemKind string
+
+const (
+	HistoryMissingOpenRow   HistoryProblemKind = "missing_open_row"
+	HistoryMultipleOpenRows HistoryProblemKind = "multiple_open_rows"
+	HistoryOpenRowDeleted   HistoryProblemKind = "open_row_for_deleted"
+	HistoryOverlappingRows  HistoryProblemKind = "overlapping_rows"
+	HistoryContentMismatch  HistoryProblemKind = "content_mismatch"
+	HistoryUnexplainedGap   HistoryProblemKind = "unexplained_gap"
+)
+
+var historyProblemDescriptions = map[HistoryProblemKind]string{
+	HistoryMissingOpenRow:   "has no open-ended history row",
+	HistoryMultipleOpenRows: "has more than one open-ended history row",
+	HistoryOpenRowDeleted:   "is deleted, but has an open-ended history row",
+	HistoryOverlappingRows:  "has overlapping history rows",
+	HistoryContentMismatch:  "does not match its open-ended history row",
+	HistoryUnexplainedGap:   "has a gap in its history that no restore explains",
+}
+
+// restoreRoutes are the routes whose change sets can put deleted rows back,
+// and so can start a history row after a gap.
+var restoreRoutes = []string{
+	routeOf(http.MethodPost, restoreTodoListRevisionPath),
+	routeOf(http.MethodPost, restoreTodosPath),
+	routeOf(http.MethodPost, mergeTodoListRevisionPath),
+	routeOf(http.MethodPost, restoreTodoRevisionPath),
+	routeOf(http.MethodPost, undoTodoListPath),
+	routeOf(http.MethodPost, redoTodoListPath),
+	routeOf(http.MethodPost, restoreTodoListRevisionAPIPath),
+}
+
+// HistoryProblem is a row whose history breaks one of the invariants.
+type HistoryProblem struct {
+	Table string
+	ID    string
+	Kind  HistoryProblemKind
+	// Repaired is set if the problem was repaired.
+	Repaired bool
+}
+
+func (p HistoryProblem) String() string {
+	s := p.Table + " " + p.ID + " " + historyProblemDescriptions[p.Kind]
+	if p.Repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+// CheckHistoryIntegrity checks the history of all versioned tables. If repair
+// is set, it also repairs what it can:
+//
+//   - Open-ended history rows of deleted rows are closed.
+//   - History rows not matching the live row are closed, and a new history
+//     row is made from the live row, as if it was just updated.
+//   - Live rows without an open-ended history row get one.
+//
+// Open-ended history rows starting in the future can't be closed now, so rows
+// with one are only reported. Overlapping rows and multiple open-ended rows
+// can't be repaired without knowing which row is right, and gaps can't be
+// filled without knowing what the row was in between, so they are only
+// reported. The repairs are made in the current transaction, and so they end
+// up in its change set.
+func CheckHistoryIntegrity(tx *Tx, repair bool) ([]HistoryProblem, error) {
+	tables, err := getVersionedTables(tx)
+	if err != nil {
+		return nil, err
+	}
+	var problems []HistoryProblem
+	for _, table := range tables {
+		tableProblems, err := checkHistoryTableIntegrity(tx, table, repair)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, tableProblems...)
+	}
+	return problems, nil
+}
+
+func checkHistoryTableIntegrity(tx *Tx, table string, repair bool) ([]HistoryProblem, error) {
+	pk, err := getPrimaryKey(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) != 1 {
+		return nil, fmt.Errorf("table %s must have a primary key of exactly one column, not %d", table, len(pk))
+	}
+	cols, err := getTableColumns(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	colNames := make(TableColumns, len(cols))
+	for i, col := range cols {
+		colNames[i] = pq.QuoteIdentifier(col.Name)
+	}
+
+	id := pq.QuoteIdentifier(pk[0])
+	qTable := pq.QuoteIdentifier(table)
+	qHistory := pq.QuoteIdentifier(table + "_history")
+
+	// an open-ended row starting in the future can't be closed now, and a new
+	// row starting now would overlap with it.
+	noFutureOpenRow := `
+SELECT NOT EXISTS (SELECT 1
+                   FROM ` + qHistory + `
+                   WHERE CAST(` + id + ` AS text) = :id
+                     AND UPPER_INF(systime)
+                     AND LOWER(systime) >= NOW())`
+
+	checks := []struct {
+		kind  HistoryProblemKind
+		query string
+		// repair fixes the problem for the id in :id, if it can be fixed.
+		repair []string
+		// repairable tells whether repair can fix the id in :id. It's always
+		// run if not set.
+		repairable string
+	}{
+		{
+			kind: HistoryOpenRowDeleted,
+			query: `
+SELECT DISTINCT CAST(h.` + id + ` AS text)
+FROM ` + qHistory + ` h
+WHERE UPPER_INF(h.systime)
+  AND NOT EXISTS (SELECT 1
+                  FROM ` + qTable + ` t
+                  WHERE t.` + id + ` = h.` + id + `)`,
+			repair: []string{`
+UPDATE ` + qHistory + `
+   SET systime = tstzrange(LOWER(systime), NOW())
+WHERE CAST(` + id + ` AS text) = :id
+  AND UPPER_INF(systime)
+  AND LOWER(systime) < NOW()`},
+			repairable: noFutureOpenRow,
+		},
+		{
+			kind: HistoryMultipleOpenRows,
+			query: `
+SELECT CAST(h.` + id + ` AS text)
+FROM ` + qHistory + ` h
+WHERE UPPER_INF(h.systime)
+GROUP BY h.` + id + `
+HAVING COUNT(*) > 1`,
+		},
+		{
+			kind: HistoryOverlappingRows,
+			query: `
+SELECT DISTINCT CAST(a.` + id + ` AS text)
+FROM ` + qHistory + ` a
+JOIN ` + qHistory + ` b
+  ON b.` + id + ` = a.` + id + `
+ AND b.history_id <> a.history_id
+ AND b.systime && a.systime`,
+		},
+		{
+			// compares the columns of the table only, not the bookkeeping
+			// columns of the history table.
+			kind: HistoryContentMismatch,
+			query: `
+SELECT CAST(t.` + id + ` AS text)
+FROM ` + qTable + ` t
+JOIN ` + qHistory + ` h
+  ON h.` + id + ` = t.` + id + `
+ AND UPPER_INF(h.systime)
+WHERE to_jsonb(t) <> (SELECT jsonb_object_agg(hc.key, hc.value)
+                      FROM jsonb_each(to_jsonb(h)) hc
+                      WHERE to_jsonb(t) ? hc.key)`,
+			repair: []string{`
+UPDATE ` + qHistory + `
+   SET systime = tstzrange(LOWER(systime), NOW())
+WHERE CAST(` + id + ` AS text) = :id
+  AND UPPER_INF(systime)
+  AND LOWER(systime) < NOW()`, `
+INSERT INTO ` + qHistory + ` (history_id, systime, ` + colNames.String() + `)
+SELECT gen_random_uuid(), tstzrange(NOW(), NULL), ` + colNames.OnAlias("t").String() + `
+FROM ` + qTable + ` t
+WHERE CAST(t.` + id + ` AS text) = :id`},
+			repairable: noFutureOpenRow,
+		},
+		{
+			kind: HistoryMissingOpenRow,
+			query: `
+SELECT CAST(t.` + id + ` AS text)
+FROM ` + qTable + ` t
+WHERE NOT EXISTS (SELECT 1
+                  FROM ` + qHistory + ` h
+                  WHERE h.` + id + ` = t.` + id + `
+                    AND UPPER_INF(h.systime))`,
+			repair: []string{`
+INSERT INTO ` + qHistory + ` (history_id, systime, ` + colNames.String() + `)
+SELECT gen_random_uuid(), tstzrange(NOW(), NULL), ` + colNames.OnAlias("t").String() + `
+FROM ` + qTable + ` t
+WHERE CAST(t.` + id + ` AS text) = :id`},
+		},
+		{
+			// a gap ends where the next history row starts, so that's the row
+			// to look for the restore of.
+			kind: HistoryUnexplainedGap,
+			query: `
+SELECT DISTINCT CAST(h.` + id + ` AS text)
+FROM (SELECT h.` + id + `
+           , h.history_id
+           , h.change_set_id
+           , h.systime
+           , LAG(UPPER(h.systime)) OVER (PARTITION BY h.` + id + ` ORDER BY LOWER(h.systime)) AS prev_upper
+      FROM ` + qHistory + ` h) h
+WHERE h.prev_upper < LOWER(h.systime)
+  AND NOT EXISTS (SELECT 1
+                  FROM todo_list_restore_revisions r
+                  WHERE r.history_id = h.history_id)
+  AND NOT EXISTS (SELECT 1
+                  FROM change_sets cs
+                  WHERE cs.change_set_id = h.change_set_id
+                    AND cs.route = ANY(CAST(:restore_routes AS text[])))`,
+		},
+	}
+
+	var problems []HistoryProblem
+	for _, check := range checks {
+		var ids []string
+		err := tx.Select(&ids, check.query, QueryArgs{
+			"restore_routes": pq.StringArray(restoreRoutes),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, badID := range ids {
+			problem := HistoryProblem{Table: table, ID: badID, Kind: check.kind}
+			canRepair := repair && check.repair != nil
+			if canRepair && check.repairable != "" {
+				err = tx.Get(&canRepair, check.repairable, QueryArgs{"id": badID})
+				if err != nil {
+					return nil, err
+				}
+			}
+			if canRepair {
+				for _, stmt := range check.repair {
+					err = tx.Exec(stmt, QueryArgs{"id": badID})
+					if err != nil {
+						return nil, fmt.Errorf("failed to repair %s: %w", problem, err)
+					}
+				}
+				problem.Repaired = true
+			}
+			problems = append(problems, problem)
+		}
+	}
+	return problems, nil
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -20,41 +25,109 @@ func main() {
 
 	runMigrations(db.DB)
 
-	s := newServer(db)
+	err = checkHistorySchemas(db)
+	if err != nil {
+		logrus.WithError(err).Fatal("refusing to start")
+	}
+
+	err = ensureHistoryPartitions(db)
+	if err != nil {
+		logrus.WithError(err).Fatal("failed to make history partitions")
+	}
+
+	if len(os.Args) > 1 {
+		err = runCommand(db, os.Args[1], os.Args[2:])
+		if err != nil {
+			logrus.WithError(err).Fatal()
+		}
+		return
+	}
+
+	trustedProxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid TRUSTED_PROXIES")
+	}
+
+	s := newServer(db, trustedProxies)
 
 	s.GETWithTx("/", indexHandler)
 	s.POSTWithTx("/todo-lists", postTodoListHandler)
 	s.GETWithTx("/todo-lists/:tlid", getTodoListHandler)
 	s.POSTWithTx("/todo-lists/:tlid/delete", deleteTodoListHandler)
 	s.POSTWithTx("/todo-lists/:tlid/new-todos", newTodosHandler)
+	s.POSTWithTx(undoTodoListPath, undoTodoListHandler)
+	s.POSTWithTx(redoTodoListPath, redoTodoListHandler)
 	s.GETWithTx("/todo-lists/:tlid/revisions", getTodoListRevisionsHandler)
+	s.GETWithTx("/todo-lists/:tlid/revisions/:n", getTodoListRevisionByNumberHandler)
+	s.GETWithTx("/todo-lists/:tlid/diff", getTodoListDiffHandler)
 
+	s.GETWithTx("/todos/:tid", getTodoHistoryHandler)
+	s.GETWithTx("/todos/:tid/history", getTodoHistoryHandler)
 	s.POSTWithTx("/todos/:tid/complete", completeTodoHandler)
 	s.POSTWithTx("/todos/:tid/reactivate", reactivateTodoHandler)
 	s.POSTWithTx("/todos/:tid/delete", deleteTodoHandler)
 
 	s.GETWithTx("/todo-lists-history/:tlhid", getTodoListRevisionHandler)
-	s.POSTWithTx("/todo-lists-history/:tlhid/restore", restoreTodoListRevisionHandler)
+	s.GETWithTx(restoreTodoListRevisionPath, getRestoreTodoListRevisionHandler)
+	s.POSTWithTx(restoreTodoListRevisionPath, restoreTodoListRevisionHandler)
+	s.POSTWithTx(restoreTodosPath, restoreTodosHandler)
+	s.POSTWithTx("/todo-lists-history/:tlhid/fork", forkTodoListRevisionHandler)
+	s.GETWithTx(mergeTodoListRevisionPath, getMergeTodoListRevisionHandler)
+	s.POSTWithTx(mergeTodoListRevisionPath, mergeTodoListRevisionHandler)
+
+	s.POSTWithTx(restoreTodoRevisionPath, restoreTodoRevisionHandler)
 
-	s.POSTWithTx("/todos-history/:thid/restore", restoreTodoRevisionHandler)
+	s.GETWithTx("/trash", getTrashHandler)
+	s.POSTWithTx("/trash/:tlid/purge", purgeTodoListHandler)
+
+	s.GETWithTx("/change-sets", getChangeSetsHandler)
+	s.GETWithTx("/change-sets/:csid", getChangeSetHandler)
+
+	s.GETWithTx("/activity", getActivityHandler)
+
+	s.GETJSONWithTx("/api/todo-lists/:tlid/diff", getTodoListDiffAPIHandler)
+	s.GETJSONWithTx("/api/todo-lists/:tlid/revisions", getTodoListRevisionsAPIHandler)
+	s.GETJSONWithTx("/api/activity", getActivityAPIHandler)
+	s.POSTJSONWithTx(restoreTodoListRevisionAPIPath, restoreTodoListRevisionAPIHandler)
+
+	go runPeriodically("make history partitions", 24*time.Hour, func() error {
+		return ensureHistoryPartitions(db)
+	})
+	go runPeriodically("make todo list checkpoints", time.Minute, func() error {
+		return writeTodoListCheckpoints(db)
+	})
 
 	s.Run()
 }
 
+// runPeriodically runs f every interval for as long as the app runs.
+func runPeriodically(name string, interval time.Duration, f func() error) {
+	for range time.Tick(interval) {
+		err := f()
+		if err != nil {
+			logrus.WithError(err).Error("failed to " + name)
+		}
+	}
+}
+
 type Context struct {
 	*gin.Context
 	Tx *Tx
+	// Actor is who's making the request, see actorFor.
+	Actor string
 }
 
 type server struct {
-	router *gin.Engine
-	db     *sqlx.DB
+	router         *gin.Engine
+	db             *sqlx.DB
+	trustedProxies []*net.IPNet
 }
 
-func newServer(db *sqlx.DB) *server {
+func newServer(db *sqlx.DB, trustedProxies []*net.IPNet) *server {
 	s := &server{
-		router: gin.New(),
-		db:     db,
+		router:         gin.New(),
+		db:             db,
+		trustedProxies: trustedProxies,
 	}
 	s.router.Use(gin.Recovery())
 	return s
@@ -76,10 +149,35 @@ func (s *server) GETWithTx(path string, handler func(*Context) (g.Node, error))
 	s.router.GET(path, s.wrapInTx(nodeHandler(handler)))
 }
 
+// The paths of the routes that put deleted rows back. They're shared with
+// restoreRoutes, which the integrity check uses to tell restores from gaps.
+const (
+	restoreTodoListRevisionPath    = "/todo-lists-history/:tlhid/restore"
+	restoreTodosPath               = "/todo-lists-history/:tlhid/restore-todos"
+	mergeTodoListRevisionPath      = "/todo-lists-history/:tlhid/merge"
+	restoreTodoRevisionPath        = "/todos-history/:thid/restore"
+	undoTodoListPath               = "/todo-lists/:tlid/undo"
+	redoTodoListPath               = "/todo-lists/:tlid/redo"
+	restoreTodoListRevisionAPIPath = "/api/todo-lists-history/:tlhid/restore"
+)
+
+// routeOf is the route recorded in the change sets of a request.
+func routeOf(method, path string) string {
+	return method + " " + path
+}
+
 func (s *server) POSTWithTx(path string, handler func(*Context) error) {
 	s.router.POST(path, s.wrapInTx(handler))
 }
 
+func (s *server) GETJSONWithTx(path string, handler func(*Context) (any, error)) {
+	s.router.GET(path, s.wrapInTx(jsonHandler(handler)))
+}
+
+func (s *server) POSTJSONWithTx(path string, handler func(*Context) (any, error)) {
+	s.router.POST(path, s.wrapInTx(jsonHandler(handler)))
+}
+
 func nodeHandler(handler func(*Context) (g.Node, error)) func(*Context) error {
 	return func(c *Context) error {
 		node, err := handler(c)
@@ -91,17 +189,90 @@ func nodeHandler(handler func(*Context) (g.Node, error)) func(*Context) error {
 	}
 }
 
+func jsonHandler(handler func(*Context) (any, error)) func(*Context) error {
+	return func(c *Context) error {
+		res, err := handler(c)
+		if err != nil {
+			return err
+		}
+		c.Context.JSON(http.StatusOK, res)
+		return nil
+	}
+}
+
 func (s *server) wrapInTx(handler func(*Context) error) gin.HandlerFunc {
 	return func(gc *gin.Context) {
-		err := RunInTx(gc.Request.Context(), s.db, func(tx *Tx) error {
+		meta := TxMeta{
+			Actor:   s.actorFor(gc),
+			Route:   routeOf(gc.Request.Method, gc.FullPath()),
+			Message: strings.TrimSpace(gc.PostForm("message")),
+		}
+		err := RunInTx(gc.Request.Context(), s.db, meta, func(tx *Tx) error {
 			return handler(&Context{
 				Context: gc,
 				Tx:      tx,
+				Actor:   meta.Actor,
 			})
 		})
 		if err != nil {
+			if strings.HasPrefix(gc.FullPath(), "/api/") {
+				gc.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
 			gc.Status(500)
 			errorNode(err).Render(gc.Writer)
 		}
 	}
 }
+
+// actorFor returns who's making the request. There's no authentication in this
+// app, so we rely on an authenticating proxy in front of us to set the user in
+// the X-Remote-User header. Anyone can set that header, so it's only trusted on
+// requests coming straight from one of the proxies in TRUSTED_PROXIES.
+func (s *server) actorFor(gc *gin.Context) string {
+	user := gc.GetHeader("X-Remote-User")
+	if user == "" {
+		return "anonymous"
+	}
+	host, _, err := net.SplitHostPort(gc.Request.RemoteAddr)
+	if err != nil {
+		return "anonymous"
+	}
+	ip := net.ParseIP(host)
+	for _, proxy := range s.trustedProxies {
+		if ip != nil && proxy.Contains(ip) {
+			return user
+		}
+	}
+	return "anonymous"
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// ranges.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxyStr := range strings.Split(s, ",") {
+		proxyStr = strings.TrimSpace(proxyStr)
+		if proxyStr == "" {
+			continue
+		}
+		if !strings.Contains(proxyStr, "/") {
+			ip := net.ParseIP(proxyStr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", proxyStr)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(proxyStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", proxyStr)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// TodoListMerge is the plan for merging an old revision of a todo list into the
+// current version of the list, instead of replacing it outright.
+//
+// Todos only in the current list were added since the revision and are kept,
+// todos only in the revision were deleted since and are added back. Todos in
+// both that differ are compared to their version in Base, the common ancestor
+// of the revision and the current list: If only the revision changed them, the
+// revision's version is taken, if only the current list did, the current
+// version is kept. Todos changed on both sides are conflicts, and the user has
+// to pick one.
+type TodoListMerge struct {
+	Revision *TodoListRevision `json:"revision"`
+	Current  *TodoList         `json:"current"`
+	// Base is nil if the revision and the current list have no revision in
+	// common, in which case every todo that differs is a conflict.
+	Base *TodoListRevision `json:"base"`
+
+	Kept      Todos               `json:"kept"`
+	Readded   TodoRevisions       `json:"readded"`
+	Taken     TodoRevisions       `json:"taken"`
+	Conflicts []TodoMergeConflict `json:"conflicts"`
+}
+
+type TodoMergeConflict struct {
+	Current  Todo         `json:"current"`
+	Revision TodoRevision `json:"revision"`
+}
+
+// NameConflict tells whether the name was changed both in the revision and in
+// the current list.
+func (m *TodoListMerge) NameConflict() bool {
+	if m.Revision.Name == m.Current.Name {
+		return false
+	}
+	return m.Base == nil || (m.Base.Name != m.Revision.Name && m.Base.Name != m.Current.Name)
+}
+
+// TakesRevisionName tells whether only the revision changed the name, so that
+// the merge takes it without asking.
+func (m *TodoListMerge) TakesRevisionName() bool {
+	return m.Revision.Name != m.Current.Name && !m.NameConflict() && m.Base.Name == m.Current.Name
+}
+
+// TodoListMergeResolution picks the revision's version of the conflicts. Any
+// conflict not mentioned keeps the current version.
+type TodoListMergeResolution struct {
+	TakeRevisionName bool
+	TakeRevision     map[TodoID]bool
+}
+
+// todoChanged tells whether two versions of a todo differ in what a merge can
+// change.
+func todoChanged(a, b Todo) bool {
+	return a.Description != b.Description || a.Completed != b.Completed
+}
+
+func PlanTodoListMerge(tx *Tx, tlhid TodoListHistoryID) (*TodoListMerge, error) {
+	tlr, err := GetTodoListRevisionByID(tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+	current, err := GetTodoListByID(tx, tlr.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("todo list %s is deleted, restore it instead of merging", tlr.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	base, err := getMergeBase(tx, tlr)
+	if err != nil {
+		return nil, err
+	}
+
+	merge := TodoListMerge{
+		Revision: tlr,
+		Current:  current,
+		Base:     base,
+	}
+
+	revTodos := make(map[TodoID]TodoRevision, len(tlr.Todos))
+	for _, todo := range tlr.Todos {
+		revTodos[todo.ID] = todo
+	}
+	curTodos := make(map[TodoID]Todo, len(current.Todos))
+	for _, todo := range current.Todos {
+		curTodos[todo.ID] = todo
+	}
+	baseTodos := map[TodoID]TodoRevision{}
+	if base != nil {
+		for _, todo := range base.Todos {
+			baseTodos[todo.ID] = todo
+		}
+	}
+
+	for _, todo := range current.Todos {
+		revTodo, ok := revTodos[todo.ID]
+		if !ok {
+			merge.Kept = append(merge.Kept, todo)
+			continue
+		}
+		if !todoChanged(revTodo.Todo, todo) {
+			continue
+		}
+		baseTodo, inBase := baseTodos[todo.ID]
+		revChanged := !inBase || todoChanged(baseTodo.Todo, revTodo.Todo)
+		curChanged := !inBase || todoChanged(baseTodo.Todo, todo)
+		switch {
+		case revChanged && curChanged:
+			merge.Conflicts = append(merge.Conflicts, TodoMergeConflict{
+				Current:  todo,
+				Revision: revTodo,
+			})
+		case revChanged:
+			merge.Taken = append(merge.Taken, revTodo)
+		}
+	}
+	for _, todo := range tlr.Todos {
+		if _, ok := curTodos[todo.ID]; !ok {
+			merge.Readded = append(merge.Readded, todo)
+		}
+	}
+	return &merge, nil
+}
+
+// getMergeBase returns the common ancestor of a revision and the current
+// version of its list, or nil if they have none.
+//
+// The parent of a revision is the revision it was restored from if it was
+// made by a restore (or undo and redo, which are restores too), and otherwise
+// the revision right before it. If the revision is an ancestor of the current
+// list, it is its own merge base.
+func getMergeBase(tx *Tx, tlr *TodoListRevision) (*TodoListRevision, error) {
+	var baseIDs []TodoListHistoryID
+	err := tx.Select(&baseIDs, `
+WITH RECURSIVE parents AS (
+  SELECT tlh.history_id
+       , LOWER(tlh.systime) AS sys_lower
+       , UPPER_INF(tlh.systime) AS current
+       , COALESCE(rr.restored_history_id,
+                  (SELECT p.history_id
+                   FROM todo_lists_history p
+                   WHERE p.todo_list_id = tlh.todo_list_id
+                     AND LOWER(p.systime) < LOWER(tlh.systime)
+                   ORDER BY LOWER(p.systime) DESC
+                   LIMIT 1)) AS parent_id
+  FROM todo_lists_history tlh
+  LEFT JOIN todo_list_restore_revisions rr
+    ON rr.history_id = tlh.history_id
+  WHERE tlh.todo_list_id = :tlid
+), revision_lineage AS (
+  SELECT p.history_id, p.parent_id
+  FROM parents p
+  WHERE p.history_id = :tlhid
+  UNION ALL
+  SELECT p.history_id, p.parent_id
+  FROM parents p
+  JOIN revision_lineage l
+    ON l.parent_id = p.history_id
+), current_lineage AS (
+  SELECT p.history_id, p.parent_id
+  FROM parents p
+  WHERE p.current
+  UNION ALL
+  SELECT p.history_id, p.parent_id
+  FROM parents p
+  JOIN current_lineage l
+    ON l.parent_id = p.history_id
+)
+SELECT p.history_id
+FROM parents p
+WHERE p.history_id IN (SELECT history_id FROM revision_lineage)
+  AND p.history_id IN (SELECT history_id FROM current_lineage)
+ORDER BY p.sys_lower DESC
+LIMIT 1`, QueryArgs{
+		"tlid":  tlr.ID,
+		"tlhid": tlr.HistoryID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(baseIDs) == 0 {
+		return nil, nil
+	}
+	return GetTodoListRevisionByID(tx, baseIDs[0])
+}
+
+// MergeTodoListRevision merges the revision into the current todo list, making
+// a single new revision of the list.
+func MergeTodoListRevision(tx *Tx, tlhid TodoListHistoryID, res TodoListMergeResolution) (*TodoListID, error) {
+	merge, err := PlanTodoListMerge(tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, todo := range merge.Readded {
+		err = tx.UpdateOne(`
+INSERT INTO todos (`+todoCols.String()+`)
+SELECT `+restoredTodoCols.String()+`
+FROM todos_history th
+WHERE th.history_id = :thid`, QueryArgs{
+			"thid": todo.HistoryID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	taken := merge.Taken
+	for _, conflict := range merge.Conflicts {
+		if res.TakeRevision[conflict.Current.ID] {
+			taken = append(taken, conflict.Revision)
+		}
+	}
+	for _, todo := range taken {
+		err = tx.UpdateOne(`
+UPDATE todos
+   SET description = :description
+     , completed = :completed
+     , valid_time = tstzrange(NOW(), NULL)
+WHERE todo_id = :id`, QueryArgs{
+			"id":          todo.ID,
+			"description": todo.Description,
+			"completed":   todo.Completed,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tl := *merge.Current
+	if merge.TakesRevisionName() || (merge.NameConflict() && res.TakeRevisionName) {
+		tl.Name = merge.Revision.Name
+	}
+	// also bumps updated_at, so we get a new revision of the list
+	_, err = UpdateTodoList(tx, tl)
+	if err != nil {
+		return nil, err
+	}
+	return &tl.ID, nil
+}
@@ -0,0 +1,82 @@
+-- Who made a change is set per transaction through the app.actor setting. If
+-- it's not set (e.g. someone fiddling around in psql), we fall back to the
+-- database user.
+CREATE FUNCTION current_actor() RETURNS TEXT AS $$
+  SELECT COALESCE(NULLIF(current_setting('app.actor', true), ''), session_user)
+$$ LANGUAGE sql STABLE;
+
+-- The triggers now copy columns by name instead of by position. That way, the
+-- history tables can have bookkeeping columns at the end which are filled in by
+-- their defaults, and we can still add new columns to both tables in pairs.
+CREATE FUNCTION history_column_list(rel OID) RETURNS TEXT AS $$
+  SELECT string_agg(quote_ident(attname), ', ' ORDER BY attnum)
+  FROM pg_attribute
+  WHERE attrelid = rel
+    AND attnum > 0
+    AND NOT attisdropped
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION copy_inserts_and_deletes_into_history() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  cols TEXT := history_column_list(TG_RELID);
+BEGIN
+  IF (TG_OP = 'INSERT') THEN
+    EXECUTE 'INSERT INTO ' || history_table ||
+      ' (history_id, systime, ' || cols || ')' ||
+      ' SELECT gen_random_uuid(), tstzrange(NOW(), null), ' || cols ||
+      ' FROM (SELECT ($1).*) AS r'
+      USING NEW;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') THEN
+    -- close current row
+    -- note: updates and then deletes for same id
+    -- in same tx will fail
+    EXECUTE 'UPDATE ' || history_table ||
+      ' SET systime = tstzrange(lower(systime), NOW())' ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' AND systime @> NOW()' USING OLD;
+    RETURN OLD;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION copy_updates_into_history() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  cols TEXT := history_column_list(TG_RELID);
+BEGIN
+  -- ignore changes inside the same tx
+  EXECUTE 'DELETE FROM ' || history_table ||
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND lower(systime) = NOW()' ||
+    ' AND upper_inf(systime)' USING NEW;
+  -- close current row
+  -- (if any, may be deleted by previous line)
+  EXECUTE 'UPDATE ' || history_table ||
+    ' SET systime = tstzrange(lower(systime), NOW())'
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND systime @> NOW()' USING NEW;
+  -- insert new row
+  EXECUTE 'INSERT INTO ' || history_table ||
+    ' (history_id, systime, ' || cols || ')' ||
+    ' SELECT gen_random_uuid(), tstzrange(NOW(), null), ' || cols ||
+    ' FROM (SELECT ($1).*) AS r'
+    USING NEW;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Existing rows are left as NULL, as we don't know who made them.
+ALTER TABLE todo_lists_history
+  ADD COLUMN changed_by TEXT;
+ALTER TABLE todo_lists_history
+  ALTER COLUMN changed_by SET DEFAULT current_actor();
+
+ALTER TABLE todos_history
+  ADD COLUMN changed_by TEXT;
+ALTER TABLE todos_history
+  ALTER COLUMN changed_by SET DEFAULT current_actor();
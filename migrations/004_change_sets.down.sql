@@ -0,0 +1,7 @@
+ALTER TABLE todos_history
+  DROP COLUMN change_set_id;
+ALTER TABLE todo_lists_history
+  DROP COLUMN change_set_id;
+
+DROP FUNCTION current_change_set_id;
+DROP TABLE change_sets;
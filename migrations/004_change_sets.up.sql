@@ -0,0 +1,47 @@
+-- A change set groups all the history rows written by a single transaction.
+CREATE TABLE change_sets (
+  change_set_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  actor TEXT NOT NULL DEFAULT current_actor(),
+  route TEXT,
+  message TEXT
+);
+
+CREATE INDEX change_sets_created_at_idx
+  ON change_sets (created_at);
+
+-- The change set for the current transaction is made the first time a history
+-- row is written, so that read-only transactions don't leave empty change sets
+-- behind. The route and message are read from the app.route and
+-- app.change_message settings.
+CREATE FUNCTION current_change_set_id() RETURNS UUID AS $$
+DECLARE
+  csid UUID := NULLIF(current_setting('app.change_set_id', true), '')::UUID;
+BEGIN
+  IF csid IS NULL THEN
+    INSERT INTO change_sets (route, message)
+    VALUES (NULLIF(current_setting('app.route', true), ''),
+            NULLIF(current_setting('app.change_message', true), ''))
+    RETURNING change_set_id INTO csid;
+    PERFORM set_config('app.change_set_id', csid::TEXT, true);
+  END IF;
+  RETURN csid;
+END;
+$$ LANGUAGE plpgsql;
+
+-- As with changed_by, rows written before change sets existed are left as NULL.
+ALTER TABLE todo_lists_history
+  ADD COLUMN change_set_id UUID REFERENCES change_sets (change_set_id);
+ALTER TABLE todo_lists_history
+  ALTER COLUMN change_set_id SET DEFAULT current_change_set_id();
+
+CREATE INDEX todo_lists_history_change_set_id_idx
+  ON todo_lists_history (change_set_id);
+
+ALTER TABLE todos_history
+  ADD COLUMN change_set_id UUID REFERENCES change_sets (change_set_id);
+ALTER TABLE todos_history
+  ALTER COLUMN change_set_id SET DEFAULT current_change_set_id();
+
+CREATE INDEX todos_history_change_set_id_idx
+  ON todos_history (change_set_id);
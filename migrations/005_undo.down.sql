@@ -0,0 +1,2 @@
+DROP TABLE todo_list_redo_stack;
+DROP TABLE todo_list_undo_revisions;
@@ -0,0 +1,16 @@
+-- Revisions made by undo or redo put the list back into the state of an earlier
+-- revision. We keep track of which one, so that undoing again continues further
+-- back in time instead of undoing the undo.
+CREATE TABLE todo_list_undo_revisions (
+  history_id UUID PRIMARY KEY,
+  restored_history_id UUID NOT NULL
+);
+
+-- The revisions that can be redone for each todo list, the one with the
+-- highest position is redone first.
+CREATE TABLE todo_list_redo_stack (
+  todo_list_id UUID NOT NULL,
+  position INT NOT NULL,
+  history_id UUID NOT NULL,
+  PRIMARY KEY (todo_list_id, position)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE todo_lists_history
+  DROP COLUMN forked_from_history_id;
+
+ALTER TABLE todo_lists
+  DROP COLUMN forked_from_history_id;
@@ -0,0 +1,7 @@
+-- The todo list revision a list was forked from, if any. This is a table
+-- column, so as always it's added in pairs.
+ALTER TABLE todo_lists
+  ADD COLUMN forked_from_history_id UUID;
+
+ALTER TABLE todo_lists_history
+  ADD COLUMN forked_from_history_id UUID;
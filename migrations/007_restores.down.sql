@@ -0,0 +1 @@
+DROP TABLE todo_list_restore_revisions;
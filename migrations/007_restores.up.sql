@@ -0,0 +1,11 @@
+-- Revisions made by restoring an earlier revision, along with the revision they
+-- restored. Undo and redo are restores as well, so we copy over what we already
+-- know from them.
+CREATE TABLE todo_list_restore_revisions (
+  history_id UUID PRIMARY KEY,
+  restored_history_id UUID NOT NULL
+);
+
+INSERT INTO todo_list_restore_revisions (history_id, restored_history_id)
+SELECT history_id, restored_history_id
+FROM todo_list_undo_revisions;
@@ -0,0 +1,5 @@
+DROP TRIGGER todo_lists_history_revision_trigger ON todo_lists_history;
+DROP FUNCTION assign_todo_list_revision;
+
+ALTER TABLE todo_lists_history
+  DROP COLUMN revision;
@@ -0,0 +1,37 @@
+-- Every todo list revision gets a number, counting up from 1 for each list. It
+-- is a bookkeeping column, so it's at the end of the history table, and it's
+-- assigned by a trigger on the history table itself as a default can't look at
+-- the other columns.
+ALTER TABLE todo_lists_history
+  ADD COLUMN revision INT;
+
+UPDATE todo_lists_history tlh
+   SET revision = numbered.revision
+FROM (SELECT history_id,
+             ROW_NUMBER() OVER (PARTITION BY todo_list_id ORDER BY systime) AS revision
+      FROM todo_lists_history) AS numbered
+WHERE numbered.history_id = tlh.history_id;
+
+ALTER TABLE todo_lists_history
+  ALTER COLUMN revision SET NOT NULL;
+
+CREATE UNIQUE INDEX todo_lists_history_revision_idx
+  ON todo_lists_history (todo_list_id, revision);
+
+-- Changes to a list are serialized by the row lock on todo_lists, so two
+-- transactions never pick the same number. The updates trigger removes
+-- revisions made earlier in the same transaction before inserting a new one,
+-- so that doesn't leave gaps in the numbering either.
+CREATE FUNCTION assign_todo_list_revision() RETURNS TRIGGER AS $$
+BEGIN
+  SELECT COALESCE(MAX(tlh.revision), 0) + 1 INTO NEW.revision
+  FROM todo_lists_history tlh
+  WHERE tlh.todo_list_id = NEW.todo_list_id;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todo_lists_history_revision_trigger
+BEFORE INSERT ON todo_lists_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE assign_todo_list_revision();
@@ -0,0 +1,5 @@
+ALTER TABLE todos_history
+  DROP COLUMN valid_time;
+
+ALTER TABLE todos
+  DROP COLUMN valid_time;
@@ -0,0 +1,38 @@
+-- valid_time is when a todo was in this state in the real world, as opposed to
+-- systime which is when the database knew about it. It's set by the app, so it
+-- can be backdated, and it's a table column, so it's added in pairs.
+ALTER TABLE todos
+  ADD COLUMN valid_time TSTZRANGE CHECK (NOT ISEMPTY(valid_time));
+
+ALTER TABLE todos_history
+  ADD COLUMN valid_time TSTZRANGE CHECK (NOT ISEMPTY(valid_time));
+
+-- Without anything better to go on, we assume everything happened when it was
+-- recorded. The backfill must not make new history rows.
+ALTER TABLE todos
+  DISABLE TRIGGER todos_history_update_trigger;
+
+UPDATE todos t
+   SET valid_time = tstzrange(LOWER(th.systime), NULL)
+FROM todos_history th
+WHERE th.todo_id = t.todo_id
+  AND UPPER_INF(th.systime);
+
+-- in case the history is missing for some reason
+UPDATE todos
+   SET valid_time = tstzrange(created_at, NULL)
+WHERE valid_time IS NULL;
+
+ALTER TABLE todos
+  ENABLE TRIGGER todos_history_update_trigger;
+
+UPDATE todos_history
+   SET valid_time = tstzrange(LOWER(systime), NULL);
+
+ALTER TABLE todos
+  ALTER COLUMN valid_time SET NOT NULL;
+ALTER TABLE todos
+  ALTER COLUMN valid_time SET DEFAULT tstzrange(NOW(), NULL);
+
+ALTER TABLE todos_history
+  ALTER COLUMN valid_time SET NOT NULL;
@@ -0,0 +1,26 @@
+CREATE OR REPLACE FUNCTION copy_inserts_and_deletes_into_history() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  cols TEXT := history_column_list(TG_RELID);
+BEGIN
+  IF (TG_OP = 'INSERT') THEN
+    EXECUTE 'INSERT INTO ' || history_table ||
+      ' (history_id, systime, ' || cols || ')' ||
+      ' SELECT gen_random_uuid(), tstzrange(NOW(), null), ' || cols ||
+      ' FROM (SELECT ($1).*) AS r'
+      USING NEW;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') THEN
+    -- close current row
+    -- note: updates and then deletes for same id
+    -- in same tx will fail
+    EXECUTE 'UPDATE ' || history_table ||
+      ' SET systime = tstzrange(lower(systime), NOW())' ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' AND systime @> NOW()' USING OLD;
+    RETURN OLD;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
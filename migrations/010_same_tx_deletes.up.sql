@@ -0,0 +1,36 @@
+-- Deleting a row which was inserted or updated earlier in the same transaction
+-- used to fail: The history row made by the insert or update starts at NOW(),
+-- so closing it at NOW() makes it empty. Such a row was never visible outside
+-- the transaction, so we remove it instead, just like the updates trigger does.
+-- The row before it, if any, was already closed at NOW() when it was replaced,
+-- so that's all there is to it.
+CREATE OR REPLACE FUNCTION copy_inserts_and_deletes_into_history() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  cols TEXT := history_column_list(TG_RELID);
+BEGIN
+  IF (TG_OP = 'INSERT') THEN
+    EXECUTE 'INSERT INTO ' || history_table ||
+      ' (history_id, systime, ' || cols || ')' ||
+      ' SELECT gen_random_uuid(), tstzrange(NOW(), null), ' || cols ||
+      ' FROM (SELECT ($1).*) AS r'
+      USING NEW;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') THEN
+    -- forget changes inside the same tx
+    EXECUTE 'DELETE FROM ' || history_table ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' AND lower(systime) = NOW()' ||
+      ' AND upper_inf(systime)' USING OLD;
+    -- close current row
+    -- (if any, may be deleted by previous line)
+    EXECUTE 'UPDATE ' || history_table ||
+      ' SET systime = tstzrange(lower(systime), NOW())' ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' AND systime @> NOW()' USING OLD;
+    RETURN OLD;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,87 @@
+-- NOW() is the start time of the transaction, not when the change is made. If a
+-- transaction that started after us changes a row and commits before we change
+-- the same row, its history rows are later than NOW(), and we would try to
+-- close a row before it started, or make a row overlapping with its row. That
+-- used to fail with a check or exclusion violation, or worse, rewrite history
+-- that's already committed.
+--
+-- Instead, we fail with a serialization failure, like Postgres does itself for
+-- concurrent changes under REPEATABLE READ. The transaction can then be retried
+-- with a later NOW(), which RunInTx does.
+CREATE FUNCTION check_history_not_ahead(history_table TEXT, id_field TEXT, r anyelement) RETURNS VOID AS $$
+DECLARE
+  latest TIMESTAMPTZ;
+BEGIN
+  EXECUTE 'SELECT max(greatest(lower(systime), upper(systime)))' ||
+    ' FROM ' || history_table ||
+    ' WHERE ' || id_field || ' = $1.' || id_field
+    INTO latest USING r;
+  IF latest > NOW() THEN
+    RAISE EXCEPTION '% was changed by a transaction that started after this one', history_table
+      USING ERRCODE = 'serialization_failure',
+            DETAIL = format('The history is at %s, this transaction started at %s.', latest, NOW()),
+            HINT = 'Retry the transaction.';
+  END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION copy_inserts_and_deletes_into_history() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  cols TEXT := history_column_list(TG_RELID);
+BEGIN
+  IF (TG_OP = 'INSERT') THEN
+    PERFORM check_history_not_ahead(history_table, id_field, NEW);
+    EXECUTE 'INSERT INTO ' || history_table ||
+      ' (history_id, systime, ' || cols || ')' ||
+      ' SELECT gen_random_uuid(), tstzrange(NOW(), null), ' || cols ||
+      ' FROM (SELECT ($1).*) AS r'
+      USING NEW;
+    RETURN NEW;
+  ELSIF (TG_OP = 'DELETE') THEN
+    PERFORM check_history_not_ahead(history_table, id_field, OLD);
+    -- forget changes inside the same tx
+    EXECUTE 'DELETE FROM ' || history_table ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' AND lower(systime) = NOW()' ||
+      ' AND upper_inf(systime)' USING OLD;
+    -- close current row
+    -- (if any, may be deleted by previous line)
+    EXECUTE 'UPDATE ' || history_table ||
+      ' SET systime = tstzrange(lower(systime), NOW())' ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' AND systime @> NOW()' USING OLD;
+    RETURN OLD;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION copy_updates_into_history() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  cols TEXT := history_column_list(TG_RELID);
+BEGIN
+  PERFORM check_history_not_ahead(history_table, id_field, NEW);
+  -- ignore changes inside the same tx
+  EXECUTE 'DELETE FROM ' || history_table ||
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND lower(systime) = NOW()' ||
+    ' AND upper_inf(systime)' USING NEW;
+  -- close current row
+  -- (if any, may be deleted by previous line)
+  EXECUTE 'UPDATE ' || history_table ||
+    ' SET systime = tstzrange(lower(systime), NOW())'
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND systime @> NOW()' USING NEW;
+  -- insert new row
+  EXECUTE 'INSERT INTO ' || history_table ||
+    ' (history_id, systime, ' || cols || ')' ||
+    ' SELECT gen_random_uuid(), tstzrange(NOW(), null), ' || cols ||
+    ' FROM (SELECT ($1).*) AS r'
+    USING NEW;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
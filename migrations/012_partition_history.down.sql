@@ -0,0 +1,54 @@
+CREATE FUNCTION unpartition_history_table(history_table TEXT, id_field TEXT) RETURNS VOID AS $$
+DECLARE
+  old_table TEXT := history_table || '_partitioned';
+BEGIN
+  EXECUTE format('ALTER TABLE %I RENAME TO %I', history_table, old_table);
+  EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING CONSTRAINTS)',
+                 history_table, old_table);
+  EXECUTE format('INSERT INTO %I SELECT * FROM %I', history_table, old_table);
+  EXECUTE format('DROP TABLE %I CASCADE', old_table);
+  EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (history_id)', history_table);
+  EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I EXCLUDE USING GIST (%I WITH =, systime WITH &&)',
+                 history_table, history_table || '_overlapping_excl', id_field);
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT unpartition_history_table('todo_lists_history', 'todo_list_id');
+SELECT unpartition_history_table('todos_history', 'todo_id');
+
+DROP FUNCTION unpartition_history_table(TEXT, TEXT);
+
+ALTER TABLE todo_lists_history
+  ADD FOREIGN KEY (change_set_id) REFERENCES change_sets (change_set_id);
+
+CREATE INDEX todo_lists_history_change_set_id_idx
+  ON todo_lists_history (change_set_id);
+
+CREATE UNIQUE INDEX todo_lists_history_revision_idx
+  ON todo_lists_history (todo_list_id, revision);
+
+CREATE OR REPLACE FUNCTION assign_todo_list_revision() RETURNS TRIGGER AS $$
+BEGIN
+  SELECT COALESCE(MAX(tlh.revision), 0) + 1 INTO NEW.revision
+  FROM todo_lists_history tlh
+  WHERE tlh.todo_list_id = NEW.todo_list_id;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todo_lists_history_revision_trigger
+BEFORE INSERT ON todo_lists_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE assign_todo_list_revision();
+
+ALTER TABLE todos_history
+  ADD FOREIGN KEY (change_set_id) REFERENCES change_sets (change_set_id);
+
+CREATE INDEX todos_history_change_set_id_idx
+  ON todos_history (change_set_id);
+
+CREATE INDEX todos_history_todo_list_id
+  ON todos_history USING GIST (todo_list_id, systime);
+
+DROP FUNCTION check_history_overlaps();
+DROP FUNCTION create_history_partition(TEXT, TEXT, DATE);
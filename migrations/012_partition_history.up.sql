@@ -0,0 +1,142 @@
+-- The history tables are partitioned by month on the start of systime, so
+-- that old history can be detached and archived. Partitions are made ahead of
+-- time by the app (see partitions.go), and rows that end up outside of them go
+-- to the default partition until their month is made.
+--
+-- Postgres can't have exclusion constraints or unique indexes spanning the
+-- partitions when the partition key is an expression. Every partition gets its
+-- own primary key and exclusion constraint, and check_history_overlaps checks
+-- for overlaps with rows in other partitions. history_id is a random UUID, so
+-- we don't bother checking that it's unique across partitions.
+
+-- Makes the partition of history_table for the month of for_month, and moves the
+-- rows in the default partition belonging to it over. Returns false if it
+-- already exists. Months are in UTC.
+CREATE FUNCTION create_history_partition(history_table TEXT, id_field TEXT, for_month DATE) RETURNS BOOLEAN AS $$
+DECLARE
+  partition_table TEXT := history_table || '_' || to_char(for_month, 'YYYY_MM');
+  from_time TIMESTAMPTZ := date_trunc('month', for_month::timestamp) AT TIME ZONE 'UTC';
+  to_time TIMESTAMPTZ := (date_trunc('month', for_month::timestamp) + INTERVAL '1 month') AT TIME ZONE 'UTC';
+BEGIN
+  IF to_regclass(quote_ident(partition_table)) IS NOT NULL THEN
+    RETURN false;
+  END IF;
+  EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING CONSTRAINTS)',
+                 partition_table, history_table);
+  EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (history_id)', partition_table);
+  EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I EXCLUDE USING GIST (%I WITH =, systime WITH &&)',
+                 partition_table, partition_table || '_overlapping_excl', id_field);
+  -- attaching fails if the default partition has rows for this month.
+  EXECUTE format('WITH moved AS (DELETE FROM %I WHERE LOWER(systime) >= $1 AND LOWER(systime) < $2 RETURNING *)'
+                 ' INSERT INTO %I SELECT * FROM moved',
+                 history_table || '_default', partition_table)
+    USING from_time, to_time;
+  EXECUTE format('ALTER TABLE %I ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+                 history_table, partition_table, from_time, to_time);
+  RETURN true;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION check_history_overlaps() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  overlapping UUID;
+BEGIN
+  EXECUTE 'SELECT history_id FROM ' || history_table ||
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND systime && $1.systime' ||
+    ' AND history_id <> $1.history_id' ||
+    ' LIMIT 1'
+    INTO overlapping USING NEW;
+  IF overlapping IS NOT NULL THEN
+    RAISE EXCEPTION 'history row % in % overlaps with history row %', NEW.history_id, history_table, overlapping
+      USING ERRCODE = 'exclusion_violation';
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Row movement between partitions is a delete and an insert, which fires the
+-- insert triggers. Moved rows already have a revision and must keep it.
+CREATE OR REPLACE FUNCTION assign_todo_list_revision() RETURNS TRIGGER AS $$
+BEGIN
+  IF NEW.revision IS NULL THEN
+    SELECT COALESCE(MAX(tlh.revision), 0) + 1 INTO NEW.revision
+    FROM todo_lists_history tlh
+    WHERE tlh.todo_list_id = NEW.todo_list_id;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Makes a partitioned copy of history_table, with partitions from the first
+-- month in it up to a couple of months ahead, and moves the rows over. Indexes,
+-- foreign keys and triggers are up to the caller.
+CREATE FUNCTION partition_history_table(history_table TEXT, id_field TEXT) RETURNS VOID AS $$
+DECLARE
+  old_table TEXT := history_table || '_unpartitioned';
+  for_month DATE;
+BEGIN
+  EXECUTE format('ALTER TABLE %I RENAME TO %I', history_table, old_table);
+  EXECUTE format('CREATE TABLE %I (LIKE %I INCLUDING DEFAULTS INCLUDING CONSTRAINTS)'
+                 ' PARTITION BY RANGE (LOWER(systime))',
+                 history_table, old_table);
+  EXECUTE format('CREATE TABLE %I PARTITION OF %I DEFAULT',
+                 history_table || '_default', history_table);
+  EXECUTE format('ALTER TABLE %I ADD PRIMARY KEY (history_id)', history_table || '_default');
+  EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I EXCLUDE USING GIST (%I WITH =, systime WITH &&)',
+                 history_table || '_default', history_table || '_default_overlapping_excl', id_field);
+
+  EXECUTE format('SELECT date_trunc(''month'', MIN(LOWER(systime) AT TIME ZONE ''UTC''))::date FROM %I', old_table)
+    INTO for_month;
+  for_month := COALESCE(for_month, date_trunc('month', NOW() AT TIME ZONE 'UTC')::date);
+  WHILE for_month <= (NOW() AT TIME ZONE 'UTC' + INTERVAL '3 months')::date LOOP
+    PERFORM create_history_partition(history_table, id_field, for_month);
+    for_month := for_month + INTERVAL '1 month';
+  END LOOP;
+
+  EXECUTE format('INSERT INTO %I SELECT * FROM %I', history_table, old_table);
+  EXECUTE format('DROP TABLE %I', old_table);
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT partition_history_table('todo_lists_history', 'todo_list_id');
+SELECT partition_history_table('todos_history', 'todo_id');
+
+DROP FUNCTION partition_history_table(TEXT, TEXT);
+
+ALTER TABLE todo_lists_history
+  ADD FOREIGN KEY (change_set_id) REFERENCES change_sets (change_set_id);
+
+CREATE INDEX todo_lists_history_change_set_id_idx
+  ON todo_lists_history (change_set_id);
+
+-- not unique anymore, as that can't span the partitions. The revision trigger
+-- still hands out unique numbers.
+CREATE INDEX todo_lists_history_revision_idx
+  ON todo_lists_history (todo_list_id, revision);
+
+CREATE TRIGGER todo_lists_history_revision_trigger
+BEFORE INSERT ON todo_lists_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE assign_todo_list_revision();
+
+CREATE TRIGGER todo_lists_history_overlaps_trigger
+AFTER INSERT OR UPDATE OF systime ON todo_lists_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE check_history_overlaps('todo_lists_history', 'todo_list_id');
+
+ALTER TABLE todos_history
+  ADD FOREIGN KEY (change_set_id) REFERENCES change_sets (change_set_id);
+
+CREATE INDEX todos_history_change_set_id_idx
+  ON todos_history (change_set_id);
+
+CREATE INDEX todos_history_todo_list_id
+  ON todos_history USING GIST (todo_list_id, systime);
+
+CREATE TRIGGER todos_history_overlaps_trigger
+AFTER INSERT OR UPDATE OF systime ON todos_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE check_history_overlaps('todos_history', 'todo_id');
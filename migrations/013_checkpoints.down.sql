@@ -0,0 +1,2 @@
+DROP INDEX todos_history_todo_list_id_lower_idx;
+DROP TABLE todo_list_checkpoints;
@@ -0,0 +1,21 @@
+-- A checkpoint is the state of a todo list at one of its revisions: The todo
+-- history rows that were current then. To get the todos as of some time, we
+-- take the checkpoint right before it, keep the rows still current at that
+-- time, and add the rows that started in between. That's bounded by the number
+-- of changes since the checkpoint, instead of growing with the whole history
+-- of the list. Checkpoints are made by the app every so many revisions (see
+-- checkpoints.go).
+CREATE TABLE todo_list_checkpoints (
+  todo_list_id UUID NOT NULL,
+  revision INT NOT NULL,
+  taken_at TIMESTAMPTZ NOT NULL,
+  todo_history_ids UUID[] NOT NULL,
+  PRIMARY KEY (todo_list_id, revision)
+);
+
+CREATE INDEX todo_list_checkpoints_taken_at_idx
+  ON todo_list_checkpoints (todo_list_id, taken_at);
+
+-- for the rows that started after a checkpoint.
+CREATE INDEX todos_history_todo_list_id_lower_idx
+  ON todos_history (todo_list_id, LOWER(systime));
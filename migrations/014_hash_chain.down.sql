@@ -0,0 +1,13 @@
+DROP TRIGGER todos_history_row_hash_trigger ON todos_history;
+ALTER TABLE todos_history
+  DROP COLUMN row_hash,
+  DROP COLUMN prev_hash;
+
+DROP TRIGGER todo_lists_history_row_hash_trigger ON todo_lists_history;
+ALTER TABLE todo_lists_history
+  DROP COLUMN row_hash,
+  DROP COLUMN prev_hash;
+
+DROP FUNCTION rehash_history_chain(TEXT, TEXT, TEXT);
+DROP FUNCTION hash_history_row();
+DROP FUNCTION history_hash_contents(anyelement);
@@ -0,0 +1,93 @@
+-- Every history row carries a hash of its contents, chained to the hash of the
+-- revision before it for the same id. Editing a history row breaks its hash,
+-- and removing one breaks the chain of the row after it. The app verifies the
+-- chains with the verify-history command.
+--
+-- prev_hash and row_hash are bookkeeping columns, filled in by a BEFORE INSERT
+-- trigger on the history table. The hash covers every column, except systime,
+-- of which only the lower bound is covered, as the upper bound is set when the
+-- row is closed.
+
+-- The contents of a history row that are hashed. Timestamps are formatted in
+-- UTC, so that the contents don't depend on the time zone of the session.
+CREATE FUNCTION history_hash_contents(r anyelement) RETURNS TEXT AS $$
+DECLARE
+  contents JSONB := to_jsonb(r) - 'row_hash';
+BEGIN
+  RETURN jsonb_set(contents, '{systime}',
+                   to_jsonb(LOWER((contents->>'systime')::tstzrange)))::text;
+END;
+$$ LANGUAGE plpgsql STABLE SET timezone = 'UTC';
+
+CREATE FUNCTION hash_history_row() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+BEGIN
+  -- rows moved between partitions are already hashed.
+  IF NEW.row_hash IS NULL THEN
+    EXECUTE 'SELECT row_hash FROM ' || history_table ||
+      ' WHERE ' || id_field || ' = $1.' || id_field ||
+      ' ORDER BY systime DESC LIMIT 1'
+      INTO NEW.prev_hash USING NEW;
+    NEW.row_hash := sha256(convert_to(history_hash_contents(NEW), 'UTF8'));
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Hashes the history of id_value, or of all ids if it's NULL, from scratch. For
+-- the existing history, and for when history is rewritten on purpose, like
+-- compaction does.
+CREATE FUNCTION rehash_history_chain(history_table TEXT, id_field TEXT, id_value TEXT) RETURNS VOID AS $$
+DECLARE
+  r RECORD;
+  prev_id TEXT;
+  prev BYTEA;
+  hash BYTEA;
+BEGIN
+  FOR r IN EXECUTE format('SELECT h.history_id, CAST(h.%I AS text) AS id'
+                          ' FROM %I h'
+                          ' WHERE $1 IS NULL OR CAST(h.%I AS text) = $1'
+                          ' ORDER BY h.%I, h.systime',
+                          id_field, history_table, id_field, id_field)
+             USING id_value LOOP
+    IF r.id IS DISTINCT FROM prev_id THEN
+      prev := NULL;
+      prev_id := r.id;
+    END IF;
+    EXECUTE format('UPDATE %I h SET prev_hash = $1 WHERE h.history_id = $2',
+                   history_table)
+      USING prev, r.history_id;
+    EXECUTE format('UPDATE %I h SET row_hash = sha256(convert_to(history_hash_contents(h), ''UTF8''))'
+                   ' WHERE h.history_id = $1 RETURNING row_hash',
+                   history_table)
+      INTO hash USING r.history_id;
+    prev := hash;
+  END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE todo_lists_history
+  ADD COLUMN prev_hash BYTEA,
+  ADD COLUMN row_hash BYTEA;
+
+SELECT rehash_history_chain('todo_lists_history', 'todo_list_id', NULL);
+
+-- named so that it runs after todo_lists_history_revision_trigger, as triggers
+-- run in alphabetical order and the revision is part of the hash.
+CREATE TRIGGER todo_lists_history_row_hash_trigger
+BEFORE INSERT ON todo_lists_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE hash_history_row('todo_lists_history', 'todo_list_id');
+
+ALTER TABLE todos_history
+  ADD COLUMN prev_hash BYTEA,
+  ADD COLUMN row_hash BYTEA;
+
+SELECT rehash_history_chain('todos_history', 'todo_id', NULL);
+
+CREATE TRIGGER todos_history_row_hash_trigger
+BEFORE INSERT ON todos_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE hash_history_row('todos_history', 'todo_id');
@@ -0,0 +1,17 @@
+DROP TRIGGER todos_history_close_valid_time_trigger ON todos_history;
+DROP FUNCTION close_superseded_valid_time();
+
+UPDATE todos_history
+   SET valid_time = tstzrange(LOWER(valid_time), NULL)
+WHERE NOT UPPER_INF(valid_time);
+
+CREATE OR REPLACE FUNCTION history_hash_contents(r anyelement) RETURNS TEXT AS $$
+DECLARE
+  contents JSONB := to_jsonb(r) - 'row_hash';
+BEGIN
+  RETURN jsonb_set(contents, '{systime}',
+                   to_jsonb(LOWER((contents->>'systime')::tstzrange)))::text;
+END;
+$$ LANGUAGE plpgsql STABLE SET timezone = 'UTC';
+
+SELECT rehash_history_chain('todos_history', 'todo_id', NULL);
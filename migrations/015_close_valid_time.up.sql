@@ -0,0 +1,56 @@
+-- A todo's valid_time used to stay open-ended in every history row, so a later
+-- state never ended the validity of the one before it. Now, when a history row
+-- is superseded by a row that's valid from a later time, the old row's
+-- valid_time is closed at that time.
+--
+-- That changes a row that's already in the history, but only at the moment its
+-- systime is closed as well: As far as the database knew while the row was
+-- current, it was valid until further notice. Queries looking at what was
+-- known at some point in time must treat the valid_time of rows that were
+-- still current then as open-ended (see attachTodosValidAsOf).
+CREATE FUNCTION close_superseded_valid_time() RETURNS TRIGGER AS $$
+BEGIN
+  -- the superseded row is the one closed right as this one starts. If it was
+  -- already closed by an earlier change in the same transaction, it's closed
+  -- again at the new row's valid time. A row backdated to before the old one
+  -- started replaces it entirely, and leaves it open.
+  UPDATE todos_history
+     SET valid_time = tstzrange(LOWER(valid_time),
+                                CASE WHEN LOWER(valid_time) < LOWER(NEW.valid_time)
+                                       THEN LOWER(NEW.valid_time)
+                                END)
+  WHERE todo_id = NEW.todo_id
+    AND UPPER(systime) = LOWER(NEW.systime);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER todos_history_close_valid_time_trigger
+BEFORE INSERT ON todos_history
+    FOR EACH ROW
+    EXECUTE PROCEDURE close_superseded_valid_time();
+
+UPDATE todos_history th
+   SET valid_time = tstzrange(LOWER(th.valid_time), LOWER(next.valid_time))
+FROM todos_history next
+WHERE next.todo_id = th.todo_id
+  AND LOWER(next.systime) = UPPER(th.systime)
+  AND LOWER(th.valid_time) < LOWER(next.valid_time);
+
+-- The upper bound of valid_time is set when a row is superseded, just like the
+-- upper bound of systime, so the hash only covers the lower bound of both.
+CREATE OR REPLACE FUNCTION history_hash_contents(r anyelement) RETURNS TEXT AS $$
+DECLARE
+  contents JSONB := to_jsonb(r) - 'row_hash';
+BEGIN
+  contents := jsonb_set(contents, '{systime}',
+                        to_jsonb(LOWER((contents->>'systime')::tstzrange)));
+  IF contents ? 'valid_time' THEN
+    contents := jsonb_set(contents, '{valid_time}',
+                          to_jsonb(LOWER((contents->>'valid_time')::tstzrange)));
+  END IF;
+  RETURN contents::text;
+END;
+$$ LANGUAGE plpgsql STABLE SET timezone = 'UTC';
+
+SELECT rehash_history_chain('todos_history', 'todo_id', NULL);
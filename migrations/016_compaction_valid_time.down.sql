@@ -0,0 +1,2 @@
+DROP INDEX todo_lists_history_todo_list_id_lower_idx;
+DROP TRIGGER todos_history_close_valid_time_on_extend_trigger ON todos_history;
@@ -0,0 +1,14 @@
+-- Compaction extends the row it keeps back over the rows it removes. The row
+-- before them must then have its valid_time closed where the kept row starts to
+-- be valid, just as if the kept row had been inserted right after it.
+CREATE TRIGGER todos_history_close_valid_time_on_extend_trigger
+BEFORE UPDATE OF systime ON todos_history
+    FOR EACH ROW
+    WHEN (LOWER(OLD.systime) IS DISTINCT FROM LOWER(NEW.systime))
+    EXECUTE PROCEDURE close_superseded_valid_time();
+
+-- Runs of todo rows are split at the start of every list revision left after
+-- compacting the list history, which looks up the last list revision starting
+-- before each todo row.
+CREATE INDEX todo_lists_history_todo_list_id_lower_idx
+  ON todo_lists_history (todo_list_id, LOWER(systime));
@@ -0,0 +1,19 @@
+CREATE OR REPLACE FUNCTION check_history_overlaps() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  overlapping UUID;
+BEGIN
+  EXECUTE 'SELECT history_id FROM ' || history_table ||
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND systime && $1.systime' ||
+    ' AND history_id <> $1.history_id' ||
+    ' LIMIT 1'
+    INTO overlapping USING NEW;
+  IF overlapping IS NOT NULL THEN
+    RAISE EXCEPTION 'history row % in % overlaps with history row %', NEW.history_id, history_table, overlapping
+      USING ERRCODE = 'exclusion_violation';
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
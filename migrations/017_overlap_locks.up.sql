@@ -0,0 +1,36 @@
+-- check_history_overlaps can't see rows written by transactions that haven't
+-- committed yet, so two transactions writing overlapping rows for the same id
+-- into different partitions could both pass it. It now takes a transaction
+-- level advisory lock on the history table and id first: The second
+-- transaction waits for the first one to finish, and then sees its rows, as
+-- every statement in a trigger gets a new snapshot under READ COMMITTED. The
+-- ids are spread over 1024 locks per table rather than one lock each, so that
+-- transactions changing lots of rows, like compaction, don't run out of room
+-- in the lock table.
+--
+-- Under REPEATABLE READ or SERIALIZABLE, the snapshot is taken at the start of
+-- the transaction, so the rows of the first transaction stay invisible and the
+-- check can still miss them.
+CREATE OR REPLACE FUNCTION check_history_overlaps() RETURNS TRIGGER AS $$
+DECLARE
+  history_table TEXT := quote_ident(tg_argv[0]);
+  id_field TEXT := quote_ident(tg_argv[1]);
+  id_value TEXT;
+  overlapping UUID;
+BEGIN
+  EXECUTE 'SELECT CAST($1.' || id_field || ' AS text)' INTO id_value USING NEW;
+  PERFORM pg_advisory_xact_lock(hashtext(history_table), hashtext(id_value) % 1024);
+
+  EXECUTE 'SELECT history_id FROM ' || history_table ||
+    ' WHERE ' || id_field || ' = $1.' || id_field ||
+    ' AND systime && $1.systime' ||
+    ' AND history_id <> $1.history_id' ||
+    ' LIMIT 1'
+    INTO overlapping USING NEW;
+  IF overlapping IS NOT NULL THEN
+    RAISE EXCEPTION 'history row % in % overlaps with history row %', NEW.history_id, history_table, overlapping
+      USING ERRCODE = 'exclusion_violation';
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,5 @@
+DROP TABLE history_chain_anchors;
+
+DROP FUNCTION forbid_anchor_changes();
+
+GRANT EXECUTE ON FUNCTION rehash_history_chain(TEXT, TEXT, TEXT) TO PUBLIC;
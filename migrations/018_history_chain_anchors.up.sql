@@ -0,0 +1,46 @@
+-- rehash_history_chain makes any chain verify again, so whoever can call it can
+-- hide an edit of the history. Only the owner of the function (the role running
+-- the migrations) may call it now, and compaction records the hashes of the
+-- heads of the chains it rewrites, so that a later rehash of an earlier row
+-- shows up in verify-history.
+--
+-- Detaching a partition removes the start of chains. The hash of the last
+-- detached row of every id is recorded, so that the first row left isn't
+-- reported as truncated.
+--
+-- Anchors are only ever added, and verify-history uses the latest one for an
+-- id. Grant INSERT on it only to the role running compaction and detaching
+-- partitions, or whoever can add anchors can bless an edit all the same.
+
+REVOKE EXECUTE ON FUNCTION rehash_history_chain(TEXT, TEXT, TEXT) FROM PUBLIC;
+
+CREATE TABLE history_chain_anchors (
+  anchor_id BIGSERIAL PRIMARY KEY,
+  history_table TEXT NOT NULL,
+  id TEXT NOT NULL,
+  history_id UUID NOT NULL,
+  row_hash BYTEA NOT NULL,
+  reason TEXT NOT NULL CHECK (reason IN ('compacted', 'detached')),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+REVOKE ALL ON history_chain_anchors FROM PUBLIC;
+
+CREATE INDEX history_chain_anchors_id_idx
+  ON history_chain_anchors (history_table, id, reason);
+
+CREATE FUNCTION forbid_anchor_changes() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'history chain anchors can only be added, not changed or removed';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER history_chain_anchors_append_only_trigger
+BEFORE UPDATE OR DELETE ON history_chain_anchors
+    FOR EACH ROW
+    EXECUTE PROCEDURE forbid_anchor_changes();
+
+CREATE TRIGGER history_chain_anchors_no_truncate_trigger
+BEFORE TRUNCATE ON history_chain_anchors
+    FOR EACH STATEMENT
+    EXECUTE PROCEDURE forbid_anchor_changes();
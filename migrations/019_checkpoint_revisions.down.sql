@@ -0,0 +1 @@
+DROP INDEX todo_lists_history_checkpoint_revision_idx;
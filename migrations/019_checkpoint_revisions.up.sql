@@ -0,0 +1,7 @@
+-- Checkpoints are made every 100 revisions (checkpointEvery in
+-- checkpoints.go), so the background job only needs to look at those
+-- revisions. The condition must be the same as in its query for the index to be
+-- used.
+CREATE INDEX todo_lists_history_checkpoint_revision_idx
+  ON todo_lists_history (todo_list_id, revision)
+  WHERE revision % 100 = 0;
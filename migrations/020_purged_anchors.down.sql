@@ -0,0 +1,8 @@
+ALTER TABLE history_chain_anchors DISABLE TRIGGER history_chain_anchors_append_only_trigger;
+DELETE FROM history_chain_anchors WHERE reason = 'purged';
+ALTER TABLE history_chain_anchors ENABLE TRIGGER history_chain_anchors_append_only_trigger;
+
+ALTER TABLE history_chain_anchors
+  DROP CONSTRAINT history_chain_anchors_reason_check,
+  ADD CONSTRAINT history_chain_anchors_reason_check
+    CHECK (reason IN ('compacted', 'detached'));
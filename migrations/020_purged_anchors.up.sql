@@ -0,0 +1,7 @@
+-- Purging a todo list, or compaction dropping all of a chain, removes the head
+-- a compaction may have anchored. They record the hash of the last row of the
+-- chain as a purged anchor, so that verify-history knows it's gone on purpose.
+ALTER TABLE history_chain_anchors
+  DROP CONSTRAINT history_chain_anchors_reason_check,
+  ADD CONSTRAINT history_chain_anchors_reason_check
+    CHECK (reason IN ('compacted', 'detached', 'purged'));
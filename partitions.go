@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// The history tables are partitioned by month on the start of systime (see
+// migrations/012_partition_history.up.sql). Rows without a partition for their
+// month end up in the default partition, which is slow to get out of, so we
+// make the partitions a couple of months ahead of time.
+
+// historyPartitionsAhead is how many months after the current one we keep
+// partitions ready for.
+const historyPartitionsAhead = 3
+
+// historyPartitionSuffix is the time layout of the month a partition is for,
+// at the end of its name, e.g. todos_history_2024_01.
+const historyPartitionSuffix = "2006_01"
+
+func getPartitionedHistoryTables(tx *Tx) ([]string, error) {
+	var tables []string
+	err := tx.Select(&tables, `
+SELECT c.relname
+FROM pg_partitioned_table pt
+JOIN pg_class c
+  ON c.oid = pt.partrelid
+WHERE c.relnamespace = to_regnamespace(current_schema())
+  AND c.relname LIKE '%\_history'
+ORDER BY c.relname`, QueryArgs{})
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// EnsureHistoryPartitions makes the partitions of all partitioned history
+// tables from the month of now and historyPartitionsAhead months ahead, if
+// they don't exist already. It returns the partitions it made.
+func EnsureHistoryPartitions(tx *Tx, now time.Time) ([]string, error) {
+	tables, err := getPartitionedHistoryTables(tx)
+	if err != nil {
+		return nil, err
+	}
+	now = now.UTC()
+	var made []string
+	for _, table := range tables {
+		pk, err := getPrimaryKey(tx, strings.TrimSuffix(table, "_history"))
+		if err != nil {
+			return nil, err
+		}
+		if len(pk) != 1 {
+			return nil, fmt.Errorf("the table of %s must have a primary key of exactly one column, not %d", table, len(pk))
+		}
+		for i := 0; i <= historyPartitionsAhead; i++ {
+			month := time.Date(now.Year(), now.Month()+time.Month(i), 1, 0, 0, 0, 0, time.UTC)
+			var created bool
+			err = tx.Get(&created, `
+SELECT create_history_partition(:table, :id_col, CAST(:month AS date))`, QueryArgs{
+				"table":  table,
+				"id_col": pk[0],
+				"month":  month.Format("2006-01-02"),
+			})
+			if err != nil {
+				return nil, err
+			}
+			if created {
+				made = append(made, table+"_"+month.Format(historyPartitionSuffix))
+			}
+		}
+	}
+	return made, nil
+}
+
+func ensureHistoryPartitions(db *sqlx.DB) error {
+	return RunInTx(context.Background(), db, TxMeta{Route: "history partitions"}, func(tx *Tx) error {
+		made, err := EnsureHistoryPartitions(tx, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, partition := range made {
+			logrus.WithField("partition", partition).Info("made history partition")
+		}
+		return nil
+	})
+}
+
+type historyPartition struct {
+	Name   string `db:"partition_name"`
+	Parent string `db:"parent_name"`
+}
+
+// DetachHistoryPartitions detaches the monthly history partitions for months
+// before the month of before, so that they can be archived and dropped. It
+// refuses to if any of them has rows that are still current, as those are
+// the history of things that still exist. The hash of the last detached row of
+// every id is kept, so that verify-history knows where the chains now start.
+// It returns the detached partitions.
+func DetachHistoryPartitions(tx *Tx, before time.Time) ([]string, error) {
+	tables, err := getPartitionedHistoryTables(tx)
+	if err != nil {
+		return nil, err
+	}
+	var partitions []historyPartition
+	err = tx.Select(&partitions, `
+SELECT c.relname AS partition_name
+     , p.relname AS parent_name
+FROM pg_inherits i
+JOIN pg_class c
+  ON c.oid = i.inhrelid
+JOIN pg_class p
+  ON p.oid = i.inhparent
+WHERE p.relname = ANY(CAST(:tables AS text[]))
+  AND p.relnamespace = to_regnamespace(current_schema())
+ORDER BY c.relname`, QueryArgs{
+		"tables": pq.StringArray(tables),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	before = before.UTC()
+	cutoff := time.Date(before.Year(), before.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var detached []string
+	for _, partition := range partitions {
+		// skips the default partition, which never can be detached.
+		month, err := time.Parse(historyPartitionSuffix, strings.TrimPrefix(partition.Name, partition.Parent+"_"))
+		if err != nil || !month.Before(cutoff) {
+			continue
+		}
+		var current bool
+		err = tx.Get(&current, `
+SELECT EXISTS (SELECT 1
+               FROM `+pq.QuoteIdentifier(partition.Name)+`
+               WHERE UPPER_INF(systime))`, QueryArgs{})
+		if err != nil {
+			return nil, err
+		}
+		if current {
+			return nil, fmt.Errorf("partition %s still has current rows", partition.Name)
+		}
+		err = anchorDetachedHistoryChains(tx, partition)
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Exec(`
+ALTER TABLE `+pq.QuoteIdentifier(partition.Parent)+`
+  DETACH PARTITION `+pq.QuoteIdentifier(partition.Name), QueryArgs{})
+		if err != nil {
+			return nil, err
+		}
+		detached = append(detached, partition.Name)
+	}
+	return detached, nil
+}
+
+// anchorDetachedHistoryChains records the hash of the last row of every id in
+// the partition, so that the rows left after it don't look truncated.
+func anchorDetachedHistoryChains(tx *Tx, partition historyPartition) error {
+	histCols, err := getTableColumns(tx, partition.Parent)
+	if err != nil {
+		return err
+	}
+	if !hasHashChain(histCols) {
+		return nil
+	}
+	pk, err := getPrimaryKey(tx, strings.TrimSuffix(partition.Parent, "_history"))
+	if err != nil {
+		return err
+	}
+	if len(pk) != 1 {
+		return fmt.Errorf("the table of %s must have a primary key of exactly one column, not %d", partition.Parent, len(pk))
+	}
+	return anchorHistoryChains(tx, partition.Parent, pk[0], pq.QuoteIdentifier(partition.Name), QueryArgs{}, "detached")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Making the history table for a table by hand is error prone: The columns
+// must match the original table exactly, and the exclusion constraint and
+// triggers must use the right names. Here we derive it all from the original
+// table instead.
+
+type tableColumn struct {
+	Name     string `db:"column_name"`
+	Type     string `db:"data_type"`
+	Nullable bool   `db:"nullable"`
+}
+
+func getTableColumns(tx *Tx, table string) ([]tableColumn, error) {
+	var cols []tableColumn
+	// information_schema has the columns, but not their exact types (e.g.
+	// arrays are just ARRAY), so we get those from pg_attribute.
+	err := tx.Select(&cols, `
+SELECT c.column_name
+     , format_type(a.atttypid, a.atttypmod) AS data_type
+     , c.is_nullable = 'YES' AS nullable
+FROM information_schema.columns c
+JOIN pg_attribute a
+  ON a.attrelid = to_regclass(quote_ident(c.table_schema) || '.' || quote_ident(c.table_name))
+ AND a.attname = c.column_name
+WHERE c.table_schema = current_schema()
+  AND c.table_name = :table
+ORDER BY c.ordinal_position`, QueryArgs{
+		"table": table,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
+func getPrimaryKey(tx *Tx, table string) ([]string, error) {
+	var cols []string
+	err := tx.Select(&cols, `
+SELECT kcu.column_name
+FROM information_schema.table_constraints tc
+JOIN information_schema.key_column_usage kcu
+  ON kcu.constraint_schema = tc.constraint_schema
+ AND kcu.constraint_name = tc.constraint_name
+WHERE tc.table_schema = current_schema()
+  AND tc.table_name = :table
+  AND tc.constraint_type = 'PRIMARY KEY'
+ORDER BY kcu.ordinal_position`, QueryArgs{
+		"table": table,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
+// HistoryTableDDL returns the statements that make table system-versioned:
+// The history table, its exclusion constraint, the row hash trigger, a copy of
+// the current rows and the triggers.
+func HistoryTableDDL(tx *Tx, table string) ([]string, error) {
+	history := table + "_history"
+
+	cols, err := getTableColumns(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+
+	histCols, err := getTableColumns(tx, history)
+	if err != nil {
+		return nil, err
+	}
+	if len(histCols) != 0 {
+		return nil, fmt.Errorf("history table %s already exists", history)
+	}
+
+	pk, err := getPrimaryKey(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	// the triggers only take a single id column.
+	if len(pk) != 1 {
+		return nil, fmt.Errorf("table %s must have a primary key of exactly one column, not %d", table, len(pk))
+	}
+	idCol := pk[0]
+
+	colDefs := []string{
+		"  -- copy these columns, always keep them at the top",
+		"  history_id UUID PRIMARY KEY,",
+		"  systime TSTZRANGE NOT NULL CHECK (NOT ISEMPTY(systime)),",
+		"",
+		"  -- table columns, in the exact same order as in " + table,
+	}
+	colNames := make(TableColumns, len(cols))
+	for i, col := range cols {
+		def := "  " + pq.QuoteIdentifier(col.Name) + " " + col.Type
+		if !col.Nullable {
+			def += " NOT NULL"
+		}
+		colDefs = append(colDefs, def+",")
+		colNames[i] = pq.QuoteIdentifier(col.Name)
+	}
+	colDefs = append(colDefs,
+		"",
+		"  -- bookkeeping columns, filled in by their defaults and the row hash trigger",
+		"  changed_by TEXT DEFAULT current_actor(),",
+		"  change_set_id UUID REFERENCES change_sets (change_set_id) DEFAULT current_change_set_id(),",
+		"  prev_hash BYTEA,",
+		"  row_hash BYTEA",
+	)
+
+	qTable := pq.QuoteIdentifier(table)
+	qHistory := pq.QuoteIdentifier(history)
+	triggerArgs := pq.QuoteLiteral(history) + ", " + pq.QuoteLiteral(idCol)
+
+	return []string{
+		"CREATE TABLE " + qHistory + " (\n" + strings.Join(colDefs, "\n") + "\n)",
+
+		"ALTER TABLE " + qHistory + "\n" +
+			"  ADD CONSTRAINT " + pq.QuoteIdentifier(history+"_overlapping_excl") + "\n" +
+			"  EXCLUDE USING GIST (" + pq.QuoteIdentifier(idCol) + " WITH =, systime WITH &&)",
+
+		"CREATE TRIGGER " + pq.QuoteIdentifier(history+"_row_hash_trigger") + "\n" +
+			"BEFORE INSERT ON " + qHistory + "\n" +
+			"    FOR EACH ROW\n" +
+			"    EXECUTE PROCEDURE hash_history_row(" + triggerArgs + ")",
+
+		// the rows already in the table have to start somewhere.
+		"INSERT INTO " + qHistory + " (history_id, systime, " + colNames.String() + ")\n" +
+			"SELECT gen_random_uuid(), tstzrange(NOW(), null), " + colNames.String() + "\n" +
+			"FROM " + qTable,
+
+		"CREATE TRIGGER " + pq.QuoteIdentifier(history+"_insert_delete_trigger") + "\n" +
+			"AFTER INSERT OR DELETE ON " + qTable + "\n" +
+			"    FOR EACH ROW\n" +
+			"    EXECUTE PROCEDURE copy_inserts_and_deletes_into_history(" + triggerArgs + ")",
+
+		"CREATE TRIGGER " + pq.QuoteIdentifier(history+"_update_trigger") + "\n" +
+			"AFTER UPDATE ON " + qTable + "\n" +
+			"    FOR EACH ROW\n" +
+			"    WHEN (OLD.* IS DISTINCT FROM NEW.*) -- to avoid updates on \"noop calls\"\n" +
+			"    EXECUTE PROCEDURE copy_updates_into_history(" + triggerArgs + ")",
+	}, nil
+}
+
+// ProvisionHistoryTable makes table system-versioned, see HistoryTableDDL.
+func ProvisionHistoryTable(tx *Tx, table string) error {
+	stmts, err := HistoryTableDDL(tx, table)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		// not through tx.Exec, as there are no parameters to bind.
+		_, err = tx.tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Every change to a todo writes a history row for the todo and for its list,
+// so the history tables grow without bound. Compaction thins out old history
+// according to a retention policy: Runs of history rows for the same todo or
+// list which are older than a tier's age are merged into a single row per
+// interval, keeping the last state in the interval. The merged row takes over
+// the systime of the rows it replaces, so the history stays contiguous and
+// as-of queries still work, they just see fewer changes.
+//
+// The todos of a list revision are looked up at the end of the revision (see
+// todosAsOf), so a merged list revision must see the todos as they were at the
+// end of the rows it replaced. The todo history is therefore compacted after
+// the list history, and runs of todo rows never span the start of a list
+// revision that's left.
+//
+// History rows referenced from elsewhere (undo, redo, restores and forks) are
+// left alone, and so are gaps in the history, i.e. deletions.
+//
+// The hash chains of the compacted ids are recomputed, so compaction refuses
+// to run if any chain is broken, and records the new heads (see hashchain.go).
+
+// RetentionInterval is how much history to keep in a tier: One revision per
+// interval, or nothing at all.
+type RetentionInterval string
+
+const (
+	RetainDaily   RetentionInterval = "day"
+	RetainWeekly  RetentionInterval = "week"
+	RetainMonthly RetentionInterval = "month"
+	RetainYearly  RetentionInterval = "year"
+	RetainNothing RetentionInterval = "drop"
+)
+
+// RetentionTier applies to history rows that ended more than After ago.
+type RetentionTier struct {
+	After time.Duration
+	Keep  RetentionInterval
+}
+
+// RetentionPolicy is a list of tiers, youngest first. History younger than the
+// first tier is kept as is.
+type RetentionPolicy []RetentionTier
+
+const day = 24 * time.Hour
+
+// DefaultRetentionPolicy keeps everything for 30 days, then one revision per
+// day for a year, then one per month.
+var DefaultRetentionPolicy = RetentionPolicy{
+	{After: 30 * day, Keep: RetainDaily},
+	{After: 365 * day, Keep: RetainMonthly},
+}
+
+// ParseRetentionPolicy parses a policy on the form "30d:day,1y:month", where
+// the ages can be given in days (d), weeks (w), years (y, 365 days) or as a Go
+// duration.
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	var policy RetentionPolicy
+	for _, tierStr := range strings.Split(s, ",") {
+		afterStr, keepStr, ok := strings.Cut(strings.TrimSpace(tierStr), ":")
+		if !ok {
+			return nil, fmt.Errorf("retention tier %q is not on the form <age>:<interval>", tierStr)
+		}
+		after, err := parseRetentionAge(afterStr)
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, RetentionTier{After: after, Keep: RetentionInterval(keepStr)})
+	}
+	err := policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func parseRetentionAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": day, "w": 7 * day, "y": 365 * day}
+	for suffix, unit := range units {
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if strings.HasSuffix(s, suffix) && err == nil {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention age %q", s)
+	}
+	return d, nil
+}
+
+func (p RetentionPolicy) Validate() error {
+	if len(p) == 0 {
+		return errors.New("retention policy has no tiers")
+	}
+	for i, tier := range p {
+		switch tier.Keep {
+		case RetainDaily, RetainWeekly, RetainMonthly, RetainYearly:
+		case RetainNothing:
+			if i != len(p)-1 {
+				return errors.New("only the last retention tier can drop history")
+			}
+		default:
+			return fmt.Errorf("unknown retention interval %q", tier.Keep)
+		}
+		if tier.After <= 0 {
+			return fmt.Errorf("retention tier %d must have a positive age", i+1)
+		}
+		if i > 0 && tier.After <= p[i-1].After {
+			return errors.New("retention tiers must be ordered from youngest to oldest")
+		}
+	}
+	return nil
+}
+
+func (p RetentionPolicy) String() string {
+	tiers := make([]string, len(p))
+	for i, tier := range p {
+		after := tier.After.String()
+		if tier.After%day == 0 {
+			after = strconv.Itoa(int(tier.After/day)) + "d"
+		}
+		tiers[i] = after + ":" + string(tier.Keep)
+	}
+	return strings.Join(tiers, ",")
+}
+
+// CompactionResult tells how many rows compaction removed from each history
+// table, and how many change sets it removed because they had no history rows
+// left.
+type CompactionResult struct {
+	TodoListRows int64
+	TodoRows     int64
+	ChangeSets   int64
+}
+
+// CompactHistory thins out the history of all todo lists and todos according to
+// policy, as of now.
+func CompactHistory(tx *Tx, policy RetentionPolicy, now time.Time) (*CompactionResult, error) {
+	err := policy.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// compaction rehashes the chains it rewrites, which would hide any edits
+	// made to them before.
+	chains, err := VerifyHistoryChains(tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(chains.Problems) != 0 {
+		return nil, fmt.Errorf("won't compact, as %d history rows have been tampered with (see verify-history)", len(chains.Problems))
+	}
+
+	err = tx.Exec(`
+CREATE TEMPORARY TABLE history_compaction (
+  history_id UUID PRIMARY KEY,
+  id TEXT NOT NULL,
+  keep BOOLEAN NOT NULL,
+  new_lower TIMESTAMPTZ
+) ON COMMIT DROP`, QueryArgs{})
+	if err != nil {
+		return nil, err
+	}
+
+	// checkpoints taken before the first cutoff may refer to rows we're about to
+	// remove or extend. New ones are only made after the latest checkpoint left
+	// of a list, so the compacted history of lists that still have one goes
+	// without, which is fine as it has few revisions anyway.
+	err = tx.Exec(`
+DELETE FROM todo_list_checkpoints
+WHERE taken_at <= CAST(:cutoff AS timestamptz)`, QueryArgs{
+		"cutoff": now.Add(-policy[0].After),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res CompactionResult
+	res.TodoListRows, err = compactHistoryTable(tx, "todo_lists_history", "todo_list_id", "NULL", `
+SELECT history_id FROM todo_list_undo_revisions
+UNION SELECT restored_history_id FROM todo_list_undo_revisions
+UNION SELECT history_id FROM todo_list_redo_stack
+UNION SELECT history_id FROM todo_list_restore_revisions
+UNION SELECT restored_history_id FROM todo_list_restore_revisions
+UNION SELECT forked_from_history_id FROM todo_lists WHERE forked_from_history_id IS NOT NULL
+UNION SELECT forked_from_history_id FROM todo_lists_history WHERE forked_from_history_id IS NOT NULL`,
+		policy, now)
+	if err != nil {
+		return nil, err
+	}
+	res.TodoRows, err = compactHistoryTable(tx, "todos_history", "todo_id", `
+(SELECT MAX(LOWER(tlh.systime))
+ FROM todo_lists_history tlh
+ WHERE tlh.todo_list_id = h.todo_list_id
+   AND LOWER(tlh.systime) <= LOWER(h.systime))`, `
+SELECT CAST(NULL AS uuid) AS history_id WHERE false`,
+		policy, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// the change sets of the removed rows are now empty, unless they changed
+	// something else too.
+	err = tx.Get(&res.ChangeSets, `
+WITH deleted AS (
+  DELETE FROM change_sets cs
+  WHERE cs.created_at < CAST(:cutoff AS timestamptz)
+    AND NOT EXISTS (SELECT 1
+                    FROM todo_lists_history tlh
+                    WHERE tlh.change_set_id = cs.change_set_id)
+    AND NOT EXISTS (SELECT 1
+                    FROM todos_history th
+                    WHERE th.change_set_id = cs.change_set_id)
+  RETURNING 1
+)
+SELECT COUNT(*) FROM deleted`, QueryArgs{
+		"cutoff": now.Add(-policy[0].After),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// compactHistoryTable compacts a single history table and returns the number
+// of rows removed. segment is an expression on the history row h, and a run of
+// rows never spans rows where it differs. referenced is a query returning the
+// history ids that must not be touched.
+func compactHistoryTable(tx *Tx, table, idCol, segment, referenced string, policy RetentionPolicy, now time.Time) (int64, error) {
+	cutoffs := make(pq.StringArray, len(policy))
+	retains := make(pq.StringArray, len(policy))
+	for i, tier := range policy {
+		cutoffs[i] = now.Add(-tier.After).Format(time.RFC3339Nano)
+		retains[i] = string(tier.Keep)
+	}
+
+	err := tx.Exec(`TRUNCATE history_compaction`, QueryArgs{})
+	if err != nil {
+		return 0, err
+	}
+
+	// Rows are grouped into runs of contiguous rows for the same id within the
+	// same tier, interval and segment. All but the last row in a run are removed, and
+	// the last row is extended back to the start of the run. In the tier
+	// dropping history, rows are removed outright, but only up to the first
+	// referenced row so that we don't make any new gaps.
+	err = tx.Exec(`
+INSERT INTO history_compaction (history_id, id, keep, new_lower)
+SELECT g.history_id
+     , CAST(g.id AS text)
+     , g.retain <> 'drop' AND g.history_id = g.last_history_id
+     , g.first_lower
+FROM (
+  SELECT r.*
+       , FIRST_VALUE(LOWER(r.systime)) OVER run AS first_lower
+       , LAST_VALUE(r.history_id) OVER run AS last_history_id
+       , COUNT(*) OVER run AS run_length
+  FROM (
+    SELECT r.*
+         , SUM(CASE WHEN r.starts_run THEN 1 ELSE 0 END) OVER w AS run_no
+         , BOOL_OR(r.referenced) OVER w AS referenced_before
+    FROM (
+      SELECT r.*
+           , NOT COALESCE(r.candidate
+                          AND LAG(r.candidate) OVER w
+                          AND LAG(UPPER(r.systime)) OVER w = LOWER(r.systime)
+                          AND LAG(r.cutoff) OVER w = r.cutoff
+                          AND LAG(r.bucket) OVER w = r.bucket
+                          AND LAG(r.segment) OVER w IS NOT DISTINCT FROM r.segment, false) AS starts_run
+      FROM (
+        SELECT h.history_id
+             , h.`+idCol+` AS id
+             , h.systime
+             , tier.cutoff
+             , tier.retain
+             , CASE WHEN tier.retain <> 'drop'
+                      THEN date_trunc(tier.retain, LOWER(h.systime))
+                    ELSE tier.cutoff
+               END AS bucket
+             , `+segment+` AS segment
+             , ref.history_id IS NOT NULL AS referenced
+             , tier.retain IS NOT NULL AND ref.history_id IS NULL AS candidate
+        FROM `+table+` h
+        LEFT JOIN (`+referenced+`) AS ref
+          ON ref.history_id = h.history_id
+        LEFT JOIN LATERAL (SELECT t.cutoff, t.retain
+                           FROM UNNEST(CAST(:cutoffs AS timestamptz[]), CAST(:retains AS text[])) AS t(cutoff, retain)
+                           WHERE UPPER(h.systime) <= t.cutoff
+                           ORDER BY t.cutoff
+                           LIMIT 1) AS tier
+          ON true
+      ) AS r
+      WINDOW w AS (PARTITION BY r.id ORDER BY r.systime)
+    ) AS r
+    WINDOW w AS (PARTITION BY r.id ORDER BY r.systime)
+  ) AS r
+  WINDOW run AS (PARTITION BY r.id, r.run_no ORDER BY r.systime
+                 ROWS BETWEEN UNBOUNDED PRECEDING AND UNBOUNDED FOLLOWING)
+) AS g
+WHERE g.candidate
+  AND CASE WHEN g.retain = 'drop'
+             THEN NOT g.referenced_before
+           ELSE g.run_length > 1
+      END`, QueryArgs{
+		"cutoffs": cutoffs,
+		"retains": retains,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// the drop tier can remove all of a chain, in which case there's no head
+	// left to anchor, and the latest anchor of it would point at a removed row.
+	err = anchorHistoryChains(tx, table, idCol, `
+(SELECT h.*
+ FROM `+table+` h
+ JOIN history_compaction hc
+   ON hc.history_id = h.history_id
+  AND NOT hc.keep
+ WHERE NOT EXISTS (SELECT 1
+                   FROM `+table+` l
+                   LEFT JOIN history_compaction lc
+                     ON lc.history_id = l.history_id
+                   WHERE l.`+pq.QuoteIdentifier(idCol)+` = h.`+pq.QuoteIdentifier(idCol)+`
+                     AND (lc.history_id IS NULL OR lc.keep)))`, QueryArgs{}, "purged")
+	if err != nil {
+		return 0, err
+	}
+
+	// delete before extending, or the extended rows would overlap with the ones
+	// they replace.
+	var removed int64
+	err = tx.Get(&removed, `
+WITH deleted AS (
+  DELETE FROM `+table+` h
+  USING history_compaction hc
+  WHERE hc.history_id = h.history_id
+    AND NOT hc.keep
+  RETURNING 1
+)
+SELECT COUNT(*) FROM deleted`, QueryArgs{})
+	if err != nil {
+		return 0, err
+	}
+	err = tx.Exec(`
+UPDATE `+table+` h
+   SET systime = tstzrange(hc.new_lower, UPPER(h.systime))
+FROM history_compaction hc
+WHERE hc.history_id = h.history_id
+  AND hc.keep`, QueryArgs{})
+	if err != nil {
+		return 0, err
+	}
+
+	// the hash chains of the compacted ids are broken now, both by the removed
+	// rows and by the new lower bounds.
+	err = tx.Exec(`
+SELECT rehash_history_chain(:table, :id_col, hc.id)
+FROM (SELECT DISTINCT id FROM history_compaction) AS hc`, QueryArgs{
+		"table":  table,
+		"id_col": idCol,
+	})
+	if err != nil {
+		return 0, err
+	}
+	err = anchorHistoryChains(tx, table, idCol, `
+(SELECT h.*
+ FROM `+table+` h
+ WHERE CAST(h.`+pq.QuoteIdentifier(idCol)+` AS text) IN (SELECT id FROM history_compaction))`, QueryArgs{}, "compacted")
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
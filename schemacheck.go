@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// historyBookkeepingCols are the columns history tables may have that aren't in
+// the table they keep the history of. They are filled in by defaults or
+// triggers on the history table, so they can be anywhere after the two
+// leading columns.
+var historyBookkeepingCols = map[string]bool{
+	"changed_by":    true,
+	"change_set_id": true,
+	"revision":      true,
+	"prev_hash":     true,
+	"row_hash":      true,
+}
+
+// getVersionedTables returns the tables which have a history table.
+func getVersionedTables(tx *Tx) ([]string, error) {
+	var tables []string
+	err := tx.Select(&tables, `
+SELECT t.table_name
+FROM information_schema.tables t
+JOIN information_schema.tables h
+  ON h.table_schema = t.table_schema
+ AND h.table_name = t.table_name || '_history'
+WHERE t.table_schema = current_schema()
+ORDER BY t.table_name`, QueryArgs{})
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// CheckHistorySchema checks that the history table of table has the same
+// columns as the table itself, with the same types and in the same order.
+// Every mismatch found is returned as its own error.
+func CheckHistorySchema(tx *Tx, table string) ([]error, error) {
+	history := table + "_history"
+	cols, err := getTableColumns(tx, table)
+	if err != nil {
+		return nil, err
+	}
+	histCols, err := getTableColumns(tx, history)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []error
+	if len(histCols) < 2 || histCols[0].Name != "history_id" || histCols[1].Name != "systime" {
+		problems = append(problems, fmt.Errorf("%s must start with the history_id and systime columns", history))
+	} else {
+		histCols = histCols[2:]
+	}
+
+	var copied []tableColumn
+	for _, col := range histCols {
+		if !historyBookkeepingCols[col.Name] {
+			copied = append(copied, col)
+		}
+	}
+
+	histTypes := make(map[string]string, len(copied))
+	for _, col := range copied {
+		histTypes[col.Name] = col.Type
+	}
+	tableTypes := make(map[string]string, len(cols))
+	for _, col := range cols {
+		tableTypes[col.Name] = col.Type
+		histType, ok := histTypes[col.Name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Errorf("%s.%s is missing from %s", table, col.Name, history))
+		case histType != col.Type:
+			problems = append(problems, fmt.Errorf("%s.%s is %s, but %s.%s is %s",
+				table, col.Name, col.Type, history, col.Name, histType))
+		}
+	}
+	for _, col := range copied {
+		if _, ok := tableTypes[col.Name]; !ok {
+			problems = append(problems, fmt.Errorf("%s.%s is not in %s", history, col.Name, table))
+		}
+	}
+
+	// only worth looking at the order if both have the same columns.
+	if len(problems) == 0 {
+		for i := range cols {
+			if cols[i].Name != copied[i].Name {
+				problems = append(problems, fmt.Errorf("the columns of %s are not in the same order as in %s: %s vs %s",
+					history, table, columnNames(copied), columnNames(cols)))
+				break
+			}
+		}
+	}
+	return problems, nil
+}
+
+func columnNames(cols []tableColumn) string {
+	names := make([]string, len(cols))
+	for i, col := range cols {
+		names[i] = col.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkHistorySchemas checks all history tables against the tables they keep
+// the history of. It's run at startup, so that a migration that only changed
+// one of them is caught before it corrupts the history.
+func checkHistorySchemas(db *sqlx.DB) error {
+	var problems []error
+	err := RunInTx(context.Background(), db, TxMeta{Route: "startup"}, func(tx *Tx) error {
+		tables, err := getVersionedTables(tx)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			tableProblems, err := CheckHistorySchema(tx, table)
+			if err != nil {
+				return err
+			}
+			problems = append(problems, tableProblems...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("history tables don't match their tables: %w", errors.Join(problems...))
+	}
+	return nil
+}
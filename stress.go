@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type StressOptions struct {
+	Workers int
+	Changes int
+	// MaxDelay is the longest a transaction waits before it changes the todo,
+	// so that transactions that start early can commit late.
+	MaxDelay time.Duration
+	Keep     bool
+}
+
+type StressResult struct {
+	ListID    TodoListID
+	TodoID    TodoID
+	Committed int64
+	Failed    int64
+}
+
+// StressHistory hammers a single todo with concurrent transactions to check
+// that the history stays consistent when NOW() is out of order, i.e. when a
+// transaction commits after another one that started later. It makes a scratch
+// list for it, and purges it afterwards unless opts.Keep is set.
+func StressHistory(db *sqlx.DB, opts StressOptions) (*StressResult, error) {
+	ctx := context.Background()
+	meta := commandTxMeta("stress-history")
+
+	var res StressResult
+	err := RunInTx(ctx, db, meta, func(tx *Tx) error {
+		tl, err := NewTodoList(tx, "Stress test "+time.Now().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		err = NewTodo(tx, tl.ID, "Toggle me")
+		if err != nil {
+			return err
+		}
+		tl, err = GetTodoListByID(tx, tl.ID)
+		if err != nil {
+			return err
+		}
+		res.ListID = tl.ID
+		res.TodoID = tl.Todos[0].ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < opts.Changes; j++ {
+				err := RunInTx(ctx, db, meta, func(tx *Tx) error {
+					delay := time.Duration(rand.Int63n(int64(opts.MaxDelay) + 1))
+					err := tx.Exec(`SELECT pg_sleep(:seconds)`, QueryArgs{
+						"seconds": delay.Seconds(),
+					})
+					if err != nil {
+						return err
+					}
+					// toggled in place rather than read and written back, as two
+					// transactions could read the same state, and the second
+					// write would then change nothing and write no history row.
+					err = tx.UpdateOne(`
+UPDATE todos
+   SET completed = NOT completed
+     , valid_time = tstzrange(NOW(), NULL)
+WHERE todo_id = :id`, QueryArgs{
+						"id": res.TodoID,
+					})
+					if err != nil {
+						return err
+					}
+					return touchList(tx, res.TodoID)
+				})
+				if err != nil {
+					atomic.AddInt64(&res.Failed, 1)
+					continue
+				}
+				atomic.AddInt64(&res.Committed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = RunInTx(ctx, db, meta, func(tx *Tx) error {
+		err := checkStressedTodo(tx, res)
+		if err != nil {
+			return err
+		}
+		if opts.Keep {
+			return nil
+		}
+		err = DeleteTodoList(tx, res.ListID)
+		if err != nil {
+			return err
+		}
+		return PurgeTodoList(tx, res.ListID)
+	})
+	if err != nil {
+		return &res, err
+	}
+	return &res, nil
+}
+
+// checkStressedTodo checks that the history of the todo has one row per
+// committed change, without gaps or overlaps, and that it ends with the todo
+// as it is now.
+func checkStressedTodo(tx *Tx, res StressResult) error {
+	revs, err := GetTodoRevisions(tx, res.TodoID, nil)
+	if err != nil {
+		return err
+	}
+	if int64(len(revs)) != res.Committed+1 {
+		return fmt.Errorf("expected %d history rows for %d committed changes, got %d",
+			res.Committed+1, res.Committed, len(revs))
+	}
+	// GetTodoRevisions returns the newest first.
+	for i := 1; i < len(revs); i++ {
+		newer, older := revs[i-1], revs[i]
+		if older.SysUpper == nil || !older.SysUpper.Equal(newer.SysLower) {
+			return fmt.Errorf("history row %s does not end when %s starts", older.HistoryID, newer.HistoryID)
+		}
+		if older.Completed == newer.Completed {
+			return fmt.Errorf("history rows %s and %s don't toggle the todo", older.HistoryID, newer.HistoryID)
+		}
+	}
+	todo, err := GetTodoByID(tx, res.TodoID)
+	if err != nil {
+		return err
+	}
+	if revs[0].SysUpper != nil || revs[0].Completed != todo.Completed {
+		return fmt.Errorf("newest history row %s does not match the todo", revs[0].HistoryID)
+	}
+	return nil
+}
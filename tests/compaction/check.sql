@@ -0,0 +1,100 @@
+-- Checks the history seeded by seed.sql after compaction against the copy it
+-- took before, and deletes the list afterwards for tests/run.sh to purge:
+--
+--   - compaction removed rows from both history tables,
+--   - every list and todo exists at exactly the same times as before, so there
+--     are no new gaps or overlaps,
+--   - every row left has the state the row it replaced had at its end, and
+--   - at the end of every list revision left, which is where its todos are
+--     looked up, the list has the same todos as before.
+
+DO $$
+DECLARE
+  list_id UUID := '00000000-0000-0000-0000-00000000c001';
+  n_before BIGINT;
+  n_after BIGINT;
+  bad TEXT;
+BEGIN
+  SELECT COUNT(*) INTO n_before FROM compaction_tests.lists_before;
+  SELECT COUNT(*) INTO n_after FROM todo_lists_history WHERE todo_list_id = list_id;
+  IF n_after >= n_before THEN
+    RAISE EXCEPTION 'compaction left % of % list history rows', n_after, n_before;
+  END IF;
+  SELECT COUNT(*) INTO n_before FROM compaction_tests.todos_before;
+  SELECT COUNT(*) INTO n_after FROM todos_history WHERE todo_list_id = list_id;
+  IF n_after >= n_before THEN
+    RAISE EXCEPTION 'compaction left % of % todo history rows', n_after, n_before;
+  END IF;
+
+  -- contiguity
+  SELECT 'list' INTO bad
+  WHERE (SELECT range_agg(systime) FROM compaction_tests.lists_before)
+        IS DISTINCT FROM
+        (SELECT range_agg(systime) FROM todo_lists_history WHERE todo_list_id = list_id);
+  IF bad IS NOT NULL THEN
+    RAISE EXCEPTION 'the list does not exist at the same times as before compaction';
+  END IF;
+  SELECT CAST(COALESCE(b.todo_id, a.todo_id) AS text) INTO bad
+  FROM (SELECT todo_id, range_agg(systime) AS covered
+        FROM compaction_tests.todos_before
+        GROUP BY todo_id) AS b
+  FULL JOIN (SELECT todo_id, range_agg(systime) AS covered
+             FROM todos_history
+             WHERE todo_list_id = list_id
+             GROUP BY todo_id) AS a
+    ON a.todo_id = b.todo_id
+  WHERE a.covered IS DISTINCT FROM b.covered
+  LIMIT 1;
+  IF bad IS NOT NULL THEN
+    RAISE EXCEPTION 'todo % does not exist at the same times as before compaction', bad;
+  END IF;
+
+  -- as-of, row by row
+  SELECT CAST(tlh.history_id AS text) INTO bad
+  FROM todo_lists_history tlh
+  JOIN compaction_tests.lists_before b
+    ON b.systime @> COALESCE(UPPER(tlh.systime) - INTERVAL '1 microsecond', LOWER(tlh.systime))
+  WHERE tlh.todo_list_id = list_id
+    AND (b.name, b.updated_at) IS DISTINCT FROM (tlh.name, tlh.updated_at)
+  LIMIT 1;
+  IF bad IS NOT NULL THEN
+    RAISE EXCEPTION 'list history row % does not have the state at its end', bad;
+  END IF;
+  SELECT CAST(th.history_id AS text) INTO bad
+  FROM todos_history th
+  JOIN compaction_tests.todos_before b
+    ON b.todo_id = th.todo_id
+   AND b.systime @> COALESCE(UPPER(th.systime) - INTERVAL '1 microsecond', LOWER(th.systime))
+  WHERE th.todo_list_id = list_id
+    AND (b.description, b.completed) IS DISTINCT FROM (th.description, th.completed)
+  LIMIT 1;
+  IF bad IS NOT NULL THEN
+    RAISE EXCEPTION 'todo history row % does not have the state at its end', bad;
+  END IF;
+
+  -- as-of, list revisions with their todos
+  SELECT CAST(tlh.revision AS text) INTO bad
+  FROM todo_lists_history tlh
+  CROSS JOIN LATERAL (SELECT COALESCE(UPPER(tlh.systime) - INTERVAL '1 microsecond', LOWER(tlh.systime)) AS at) AS t
+  WHERE tlh.todo_list_id = list_id
+    AND (SELECT array_agg((b.todo_id, b.description, b.completed) ORDER BY b.todo_id)
+         FROM compaction_tests.todos_before b
+         WHERE b.systime @> t.at)
+        IS DISTINCT FROM
+        (SELECT array_agg((th.todo_id, th.description, th.completed) ORDER BY th.todo_id)
+         FROM todos_history th
+         WHERE th.todo_list_id = list_id
+           AND th.systime @> t.at)
+  LIMIT 1;
+  IF bad IS NOT NULL THEN
+    RAISE EXCEPTION 'list revision % does not have the todos it had before compaction', bad;
+  END IF;
+END;
+$$;
+
+-- the list is deleted the way the app does it, and then purged by tests/run.sh,
+-- which checks that the chains compaction anchored can be purged.
+BEGIN;
+DELETE FROM todo_lists WHERE todo_list_id = '00000000-0000-0000-0000-00000000c001';
+DROP SCHEMA compaction_tests CASCADE;
+COMMIT;
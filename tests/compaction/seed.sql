@@ -0,0 +1,109 @@
+-- Seeds a todo list with four months of history for the compaction check, and
+-- takes a copy of it to compare with afterwards. Run it against a migrated
+-- database, then compact the history, then run check.sql (tests/run.sh does
+-- all of that):
+--
+--   PGPASSWORD=mySecretPassword psql -h localhost -p 10840 -U postgres \
+--     -v ON_ERROR_STOP=1 -f tests/compaction/seed.sql postgres
+--
+-- The list changes every 6 hours, up to two days ago. Its todos change along
+-- with it: One is toggled, one renamed, one deleted halfway and one added
+-- later on. The history is written straight into the history tables, as the
+-- triggers can only write history starting now.
+
+BEGIN;
+
+DROP SCHEMA IF EXISTS compaction_tests CASCADE;
+CREATE SCHEMA compaction_tests;
+
+CREATE TABLE compaction_tests.seed (
+  base TIMESTAMPTZ NOT NULL,
+  last_k INT NOT NULL
+);
+
+INSERT INTO compaction_tests.seed (base, last_k)
+VALUES (date_trunc('day', NOW()) - INTERVAL '120 days', 472);
+
+-- the time of event k
+CREATE FUNCTION compaction_tests.event_time(k INT) RETURNS TIMESTAMPTZ AS $$
+  SELECT s.base + k * INTERVAL '6 hours' FROM compaction_tests.seed s;
+$$ LANGUAGE sql STABLE;
+
+CREATE TABLE compaction_tests.todo_events AS
+SELECT e.todo_id
+     , e.k
+     , COALESCE(e.end_k, LEAD(e.k) OVER (PARTITION BY e.todo_id ORDER BY e.k)) AS upper_k
+     , e.description
+     , e.completed
+FROM (SELECT '00000000-0000-0000-0000-00000000c011'::uuid AS todo_id, 2 * j AS k,
+             CAST(NULL AS int) AS end_k, 'Toggled' AS description, j % 2 = 1 AS completed
+      FROM generate_series(0, 236) j
+      UNION ALL
+      SELECT '00000000-0000-0000-0000-00000000c012', 4 * j, NULL, 'Renamed ' || j, false
+      FROM generate_series(0, 118) j
+      UNION ALL
+      SELECT '00000000-0000-0000-0000-00000000c013', 0, 200, 'Deleted', false
+      UNION ALL
+      SELECT '00000000-0000-0000-0000-00000000c014', 250, NULL, 'Late', false
+      UNION ALL
+      SELECT '00000000-0000-0000-0000-00000000c014', k, NULL, 'Late', (k / 3) % 2 = 1
+      FROM generate_series(252, 472, 3) k) AS e;
+
+INSERT INTO todo_lists_history (history_id, systime, todo_list_id, name, created_at, updated_at)
+SELECT gen_random_uuid()
+     , tstzrange(compaction_tests.event_time(k),
+                 CASE WHEN k < s.last_k THEN compaction_tests.event_time(k + 1) END)
+     , '00000000-0000-0000-0000-00000000c001'
+     , 'Compaction test ' || k / 10
+     , s.base
+     , compaction_tests.event_time(k)
+FROM compaction_tests.seed s
+CROSS JOIN generate_series(0, s.last_k) k
+ORDER BY k;
+
+-- in order, so that the hash chains and the valid times come out right.
+INSERT INTO todos_history (history_id, systime, todo_id, todo_list_id, description, created_at, completed, valid_time)
+SELECT gen_random_uuid()
+     , tstzrange(compaction_tests.event_time(e.k),
+                 CASE WHEN e.upper_k IS NOT NULL THEN compaction_tests.event_time(e.upper_k) END)
+     , e.todo_id
+     , '00000000-0000-0000-0000-00000000c001'
+     , e.description
+     , s.base
+     , e.completed
+     , tstzrange(compaction_tests.event_time(e.k), NULL)
+FROM compaction_tests.todo_events e
+CROSS JOIN compaction_tests.seed s
+ORDER BY e.todo_id, e.k;
+
+-- the live rows match the open history rows, so they're inserted without the
+-- triggers.
+ALTER TABLE todo_lists DISABLE TRIGGER USER;
+ALTER TABLE todos DISABLE TRIGGER USER;
+
+INSERT INTO todo_lists (todo_list_id, name, created_at, updated_at)
+SELECT tlh.todo_list_id, tlh.name, tlh.created_at, tlh.updated_at
+FROM todo_lists_history tlh
+WHERE tlh.todo_list_id = '00000000-0000-0000-0000-00000000c001'
+  AND UPPER_INF(tlh.systime);
+
+INSERT INTO todos (todo_id, todo_list_id, description, created_at, completed, valid_time)
+SELECT th.todo_id, th.todo_list_id, th.description, th.created_at, th.completed, th.valid_time
+FROM todos_history th
+WHERE th.todo_list_id = '00000000-0000-0000-0000-00000000c001'
+  AND UPPER_INF(th.systime);
+
+ALTER TABLE todo_lists ENABLE TRIGGER USER;
+ALTER TABLE todos ENABLE TRIGGER USER;
+
+CREATE TABLE compaction_tests.lists_before AS
+SELECT tlh.systime, tlh.name, tlh.updated_at
+FROM todo_lists_history tlh
+WHERE tlh.todo_list_id = '00000000-0000-0000-0000-00000000c001';
+
+CREATE TABLE compaction_tests.todos_before AS
+SELECT th.todo_id, th.systime, th.description, th.completed
+FROM todos_history th
+WHERE th.todo_list_id = '00000000-0000-0000-0000-00000000c001';
+
+COMMIT;
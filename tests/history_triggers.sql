@@ -0,0 +1,173 @@
+-- Checks that the history triggers handle any sequence of inserts, updates and
+-- deletes of the same row within a single transaction. Run it against a
+-- migrated database:
+--
+--   PGPASSWORD=mySecretPassword psql -h localhost -p 10840 -U postgres \
+--     -v ON_ERROR_STOP=1 -f tests/history_triggers.sql postgres
+--
+-- Everything happens in its own schema, which is dropped afterwards.
+
+DROP SCHEMA IF EXISTS history_trigger_tests CASCADE;
+CREATE SCHEMA history_trigger_tests;
+SET search_path = history_trigger_tests, public;
+
+CREATE TABLE items (
+  item_id INT PRIMARY KEY,
+  val TEXT NOT NULL
+);
+
+CREATE TABLE items_history (
+  history_id UUID PRIMARY KEY,
+  systime TSTZRANGE NOT NULL CHECK (NOT ISEMPTY(systime)),
+
+  item_id INT NOT NULL,
+  val TEXT NOT NULL
+);
+
+ALTER TABLE items_history
+  ADD CONSTRAINT items_history_overlapping_excl
+  EXCLUDE USING GIST (item_id WITH =, systime WITH &&);
+
+CREATE TRIGGER items_history_insert_delete_trigger
+AFTER INSERT OR DELETE ON items
+    FOR EACH ROW
+    EXECUTE PROCEDURE copy_inserts_and_deletes_into_history('items_history', 'item_id');
+
+CREATE TRIGGER items_history_update_trigger
+AFTER UPDATE ON items
+    FOR EACH ROW
+    WHEN (OLD.* IS DISTINCT FROM NEW.*)
+    EXECUTE PROCEDURE copy_updates_into_history('items_history', 'item_id');
+
+-- Sums up the history of an item as its values, oldest first. The value of the
+-- open-ended row is marked with a +, and a / marks a gap, i.e. the item was
+-- deleted and later inserted again.
+CREATE FUNCTION history_of(id INT) RETURNS TEXT AS $$
+  SELECT COALESCE(string_agg(
+           CASE WHEN prev_upper IS NOT NULL AND prev_upper <> LOWER(systime) THEN '/' ELSE '' END
+           || val
+           || CASE WHEN UPPER_INF(systime) THEN '+' ELSE '' END,
+           ',' ORDER BY systime), '')
+  FROM (SELECT h.*, LAG(UPPER(h.systime)) OVER (ORDER BY h.systime) AS prev_upper
+        FROM items_history h
+        WHERE h.item_id = id) AS h
+$$ LANGUAGE sql;
+
+CREATE FUNCTION assert_history(id INT, description TEXT, expected TEXT) RETURNS VOID AS $$
+DECLARE
+  actual TEXT := history_of(id);
+  live TEXT := (SELECT val FROM items WHERE item_id = id);
+  open_row TEXT := (SELECT val FROM items_history WHERE item_id = id AND UPPER_INF(systime));
+BEGIN
+  IF actual IS DISTINCT FROM expected THEN
+    RAISE EXCEPTION '%: expected history %, got %', description, expected, actual;
+  END IF;
+  IF live IS DISTINCT FROM open_row THEN
+    RAISE EXCEPTION '%: the item is %, but the open history row is %', description, live, open_row;
+  END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+-- The items from 1 to 10 exist before the transaction under test, with the
+-- value a.
+BEGIN;
+INSERT INTO items
+SELECT id, 'a' FROM generate_series(1, 10) AS id;
+COMMIT;
+
+-- make sure the transaction under test starts at a later time.
+SELECT pg_sleep(0.01);
+
+BEGIN;
+-- existing items
+UPDATE items SET val = 'b' WHERE item_id = 1;
+
+DELETE FROM items WHERE item_id = 2;
+
+UPDATE items SET val = 'b' WHERE item_id = 3;
+UPDATE items SET val = 'c' WHERE item_id = 3;
+
+UPDATE items SET val = 'b' WHERE item_id = 4;
+DELETE FROM items WHERE item_id = 4;
+
+DELETE FROM items WHERE item_id = 5;
+INSERT INTO items VALUES (5, 'b');
+
+UPDATE items SET val = 'b' WHERE item_id = 6;
+UPDATE items SET val = 'c' WHERE item_id = 6;
+UPDATE items SET val = 'd' WHERE item_id = 6;
+
+UPDATE items SET val = 'b' WHERE item_id = 7;
+UPDATE items SET val = 'c' WHERE item_id = 7;
+DELETE FROM items WHERE item_id = 7;
+
+UPDATE items SET val = 'b' WHERE item_id = 8;
+DELETE FROM items WHERE item_id = 8;
+INSERT INTO items VALUES (8, 'c');
+
+DELETE FROM items WHERE item_id = 9;
+INSERT INTO items VALUES (9, 'b');
+UPDATE items SET val = 'c' WHERE item_id = 9;
+
+DELETE FROM items WHERE item_id = 10;
+INSERT INTO items VALUES (10, 'b');
+DELETE FROM items WHERE item_id = 10;
+
+-- new items
+INSERT INTO items VALUES (11, 'b');
+
+INSERT INTO items VALUES (12, 'b');
+UPDATE items SET val = 'c' WHERE item_id = 12;
+
+INSERT INTO items VALUES (13, 'b');
+DELETE FROM items WHERE item_id = 13;
+
+INSERT INTO items VALUES (14, 'b');
+UPDATE items SET val = 'c' WHERE item_id = 14;
+UPDATE items SET val = 'd' WHERE item_id = 14;
+
+INSERT INTO items VALUES (15, 'b');
+UPDATE items SET val = 'c' WHERE item_id = 15;
+DELETE FROM items WHERE item_id = 15;
+
+INSERT INTO items VALUES (16, 'b');
+DELETE FROM items WHERE item_id = 16;
+INSERT INTO items VALUES (16, 'c');
+COMMIT;
+
+SELECT assert_history(1, 'update', 'a,b+');
+SELECT assert_history(2, 'delete', 'a');
+SELECT assert_history(3, 'update, update', 'a,c+');
+SELECT assert_history(4, 'update, delete', 'a');
+SELECT assert_history(5, 'delete, insert', 'a,b+');
+SELECT assert_history(6, 'update, update, update', 'a,d+');
+SELECT assert_history(7, 'update, update, delete', 'a');
+SELECT assert_history(8, 'update, delete, insert', 'a,c+');
+SELECT assert_history(9, 'delete, insert, update', 'a,c+');
+SELECT assert_history(10, 'delete, insert, delete', 'a');
+SELECT assert_history(11, 'insert', 'b+');
+SELECT assert_history(12, 'insert, update', 'c+');
+SELECT assert_history(13, 'insert, delete', '');
+SELECT assert_history(14, 'insert, update, update', 'd+');
+SELECT assert_history(15, 'insert, update, delete', '');
+SELECT assert_history(16, 'insert, delete, insert', 'c+');
+
+-- and the history can be continued in later transactions.
+SELECT pg_sleep(0.01);
+
+BEGIN;
+UPDATE items SET val = 'z';
+INSERT INTO items
+SELECT id, 'z' FROM generate_series(1, 16) AS id
+ON CONFLICT DO NOTHING;
+COMMIT;
+
+SELECT assert_history(1, 'later update', 'a,b,z+');
+SELECT assert_history(2, 'later insert', 'a,/z+');
+SELECT assert_history(9, 'later update after delete, insert', 'a,c,z+');
+SELECT assert_history(13, 'later insert after insert, delete', 'z+');
+SELECT assert_history(16, 'later update after insert, delete, insert', 'c,z+');
+
+DROP SCHEMA history_trigger_tests CASCADE;
+
+SELECT 'all history trigger tests passed' AS result;
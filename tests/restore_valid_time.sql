@@ -0,0 +1,90 @@
+-- Checks that a todo put back from its history is valid from then on, and not
+-- only until its old row was superseded. The restore is the insert the app
+-- makes when restoring, undoing, redoing or merging (see restoredTodoCols in
+-- todos_history.go). Run it against a migrated database:
+--
+--   PGPASSWORD=mySecretPassword psql -h localhost -p 10840 -U postgres \
+--     -v ON_ERROR_STOP=1 -f tests/restore_valid_time.sql postgres
+--
+-- The list and its history are removed afterwards.
+
+BEGIN;
+INSERT INTO todo_lists (todo_list_id, name)
+VALUES ('00000000-0000-0000-0000-00000000d001', 'restore test');
+INSERT INTO todos (todo_id, todo_list_id, description)
+VALUES ('00000000-0000-0000-0000-00000000d011', '00000000-0000-0000-0000-00000000d001', 'a');
+COMMIT;
+
+SELECT pg_sleep(0.01);
+
+-- supersedes a, which closes its valid time.
+BEGIN;
+UPDATE todos
+   SET description = 'b'
+     , valid_time = tstzrange(NOW(), NULL)
+WHERE todo_id = '00000000-0000-0000-0000-00000000d011';
+COMMIT;
+
+SELECT pg_sleep(0.01);
+
+BEGIN;
+DELETE FROM todos WHERE todo_id = '00000000-0000-0000-0000-00000000d011';
+COMMIT;
+
+SELECT pg_sleep(0.01);
+
+-- puts a back.
+BEGIN;
+INSERT INTO todos (todo_id, todo_list_id, description, created_at, completed, valid_time)
+SELECT th.todo_id, th.todo_list_id, th.description, th.created_at, th.completed,
+       tstzrange(NOW(), NULL)
+FROM todos_history th
+WHERE th.todo_id = '00000000-0000-0000-0000-00000000d011'
+  AND th.description = 'a';
+COMMIT;
+
+SELECT pg_sleep(0.01);
+
+DO $$
+DECLARE
+  todo UUID := '00000000-0000-0000-0000-00000000d011';
+BEGIN
+  IF (SELECT UPPER_INF(th.valid_time)
+      FROM todos_history th
+      WHERE th.todo_id = todo
+        AND th.description = 'a'
+        AND NOT UPPER_INF(th.systime)) THEN
+    RAISE EXCEPTION 'the superseded row of a still has an open valid time, so this tests nothing';
+  END IF;
+  IF (SELECT NOT UPPER_INF(t.valid_time) FROM todos t WHERE t.todo_id = todo) THEN
+    RAISE EXCEPTION 'the restored todo has a valid time that has ended';
+  END IF;
+  IF (SELECT NOT UPPER_INF(th.valid_time)
+      FROM todos_history th
+      WHERE th.todo_id = todo
+        AND UPPER_INF(th.systime)) THEN
+    RAISE EXCEPTION 'the history row of the restored todo has a valid time that has ended';
+  END IF;
+  -- the bitemporal query from the README, as of now and valid as of now.
+  IF (SELECT th.description
+      FROM todos_history th
+      WHERE th.todo_id = todo
+        AND LOWER(th.systime) <= NOW()
+        AND LOWER(th.valid_time) <= NOW()
+        AND (UPPER_INF(th.valid_time)
+             OR UPPER(th.valid_time) > NOW()
+             OR UPPER(th.systime) > NOW())
+      ORDER BY th.systime DESC
+      LIMIT 1) IS DISTINCT FROM 'a' THEN
+    RAISE EXCEPTION 'the restored todo is not valid now';
+  END IF;
+END;
+$$;
+
+BEGIN;
+DELETE FROM todo_lists WHERE todo_list_id = '00000000-0000-0000-0000-00000000d001';
+DELETE FROM todos_history WHERE todo_list_id = '00000000-0000-0000-0000-00000000d001';
+DELETE FROM todo_lists_history WHERE todo_list_id = '00000000-0000-0000-0000-00000000d001';
+COMMIT;
+
+SELECT 'all restore valid time tests passed' AS result;
@@ -0,0 +1,44 @@
+#!/usr/bin/env bash
+
+# Runs the checks against a fresh database: The SQL scripts in this directory,
+# the history integrity check, the concurrency stress test and the compaction
+# check. Fails on the first check that fails.
+
+set -euo pipefail
+
+pgpass=mySecretPassword
+pgport=${POSTGRES_PORT:-10840}
+
+DIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )" >/dev/null 2>&1 && pwd )"
+cd "${DIR}/.."
+
+./setup-db.sh
+go build
+
+# the app migrates the database before it runs a command.
+./time-travelling-todo-lists-in-postgres check-history
+
+run_sql() {
+    PGPASSWORD="${pgpass}" psql -h localhost -p "${pgport}" -U postgres \
+        -q -v ON_ERROR_STOP=1 -f "$1" postgres
+}
+
+for script in tests/*.sql; do
+    echo "Running ${script}"
+    run_sql "${script}"
+done
+
+echo "Running stress-history"
+./time-travelling-todo-lists-in-postgres stress-history -workers 20 -changes 20
+
+echo "Running compaction check"
+run_sql tests/compaction/seed.sql
+./time-travelling-todo-lists-in-postgres compact-history -policy 7d:day,45d:week
+./time-travelling-todo-lists-in-postgres verify-history
+./time-travelling-todo-lists-in-postgres check-history
+run_sql tests/compaction/check.sql
+
+echo "Running purge after compaction"
+./time-travelling-todo-lists-in-postgres purge-todo-list 00000000-0000-0000-0000-00000000c001
+./time-travelling-todo-lists-in-postgres verify-history
+./time-travelling-todo-lists-in-postgres compact-history -policy 7d:day,45d:week
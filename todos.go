@@ -1,20 +1,29 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
 
 type TodoListBase struct {
-	ID        TodoListID `db:"todo_list_id"`
-	Name      string     `db:"name"`
-	CreatedAt time.Time  `db:"created_at"`
-	UpdatedAt time.Time  `db:"updated_at"`
+	ID        TodoListID `db:"todo_list_id" json:"todo_list_id"`
+	Name      string     `db:"name" json:"name"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at" json:"updated_at"`
+
+	ForkedFrom *TodoListHistoryID `db:"forked_from_history_id" json:"forked_from_history_id"`
 }
 
 type TodoList struct {
 	TodoListBase
-	Todos Todos
+	Todos Todos `json:"todos"`
 }
 
-var todoListCols = TableColumns{"todo_list_id", "name", "created_at", "updated_at"}
+var todoListCols = TableColumns{"todo_list_id", "name", "created_at", "updated_at", "forked_from_history_id"}
 
 func GetAllTodoLists(tx *Tx) ([]TodoListBase, error) {
 	var tls []TodoListBase
@@ -85,11 +94,69 @@ WHERE todo_list_id = :id`, QueryArgs{
 }
 
 type Todo struct {
-	ID          TodoID     `db:"todo_id"`
-	ListID      TodoListID `db:"todo_list_id"`
-	Description string     `db:"description"`
-	CreatedAt   time.Time  `db:"created_at"`
-	Completed   bool       `db:"completed"`
+	ID          TodoID     `db:"todo_id" json:"todo_id"`
+	ListID      TodoListID `db:"todo_list_id" json:"todo_list_id"`
+	Description string     `db:"description" json:"description"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	Completed   bool       `db:"completed" json:"completed"`
+	ValidTime   ValidTime  `db:"valid_time" json:"valid_time"`
+}
+
+// ValidTime is when a todo was in its state in the real world. Unlike systime,
+// it's set by us, so that we can record things that happened in the past.
+type ValidTime struct {
+	From time.Time
+	// To is zero if the state is still valid.
+	To time.Time
+}
+
+// Scan implements the sql.Scanner interface, reading a tstzrange.
+func (vt *ValidTime) Scan(value any) error {
+	var str string
+	switch v := value.(type) {
+	case []byte:
+		str = string(v)
+	case string:
+		str = v
+	default:
+		return fmt.Errorf("cannot scan %T into a valid time", value)
+	}
+
+	if len(str) < 2 || !strings.ContainsRune("[(", rune(str[0])) || !strings.ContainsRune(")]", rune(str[len(str)-1])) {
+		return fmt.Errorf("unexpected valid time %q", str)
+	}
+	lower, upper, ok := strings.Cut(str[1:len(str)-1], ",")
+	if !ok {
+		return fmt.Errorf("unexpected valid time %q", str)
+	}
+
+	*vt = ValidTime{}
+	var err error
+	if lower = strings.Trim(lower, `"`); lower != "" {
+		vt.From, err = pq.ParseTimestamp(nil, lower)
+		if err != nil {
+			return err
+		}
+	}
+	if upper = strings.Trim(upper, `"`); upper != "" {
+		vt.To, err = pq.ParseTimestamp(nil, upper)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (vt ValidTime) MarshalJSON() ([]byte, error) {
+	var to *time.Time
+	if !vt.To.IsZero() {
+		to = &vt.To
+	}
+	return json.Marshal(struct {
+		From time.Time  `json:"from"`
+		To   *time.Time `json:"to"`
+	}{vt.From, to})
 }
 
 type Todos []Todo
@@ -104,7 +171,7 @@ func (ts Todos) FilterByCompleted(completed bool) Todos {
 	return res
 }
 
-var todoCols = TableColumns{"todo_id", "todo_list_id", "description", "created_at", "completed"}
+var todoCols = TableColumns{"todo_id", "todo_list_id", "description", "created_at", "completed", "valid_time"}
 
 func (tl *TodoList) attachTodos(tx *Tx) error {
 	err := tx.Select(&tl.Todos, `
@@ -147,13 +214,20 @@ RETURNING todo_id`,
 	return touchList(tx, tid)
 }
 
-func SetTodoCompleted(tx *Tx, tid TodoID, completed bool) error {
+// SetTodoCompleted marks the todo as completed or not. If validFrom is set, the
+// change is recorded as having happened at that time rather than right now.
+func SetTodoCompleted(tx *Tx, tid TodoID, completed bool, validFrom *time.Time) error {
 	err := tx.UpdateOne(`
 UPDATE todos
    SET completed = :completed
+     , valid_time = CASE WHEN completed = :completed AND CAST(:valid_from AS timestamptz) IS NULL
+                         THEN valid_time
+                         ELSE tstzrange(COALESCE(CAST(:valid_from AS timestamptz), NOW()), NULL)
+                    END
 WHERE todo_id = :id`, QueryArgs{
-		"id":        tid,
-		"completed": completed,
+		"id":         tid,
+		"completed":  completed,
+		"valid_from": validFrom,
 	})
 	if err != nil {
 		return err
@@ -166,12 +240,29 @@ func DeleteTodo(tx *Tx, tid TodoID) error {
 	if err != nil {
 		return err
 	}
-	err = tx.DeleteOne(`
+	return deleteTodo(tx, tid)
+}
+
+// deleteTodo deletes the todo without touching the list, for when the caller
+// touches it afterwards.
+func deleteTodo(tx *Tx, tid TodoID) error {
+	return tx.DeleteOne(`
 DELETE FROM todos
 WHERE todo_id = :id`, QueryArgs{
 		"id": tid,
 	})
-	return err
+}
+
+// touchTodoList bumps the updated_at field on the list, forcing a new version
+// of the todo list to be stored in the history table.
+func touchTodoList(tx *Tx, tlid TodoListID) error {
+	return tx.UpdateOne(`
+UPDATE todo_lists
+  SET updated_at = NOW()
+WHERE todo_list_id = :tlid`,
+		QueryArgs{
+			"tlid": tlid,
+		})
 }
 
 // touchList bumps the updated_at field on the list this todo is in, forcing a
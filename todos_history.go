@@ -3,65 +3,332 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 )
 
 type TodoListRevisionBase struct {
-	HistoryID TodoListHistoryID `db:"history_id"`
-	SysLower  time.Time         `db:"sys_lower"`
-	SysUpper  *time.Time        `db:"sys_upper"`
+	HistoryID   TodoListHistoryID `db:"history_id" json:"history_id"`
+	SysLower    time.Time         `db:"sys_lower" json:"sys_lower"`
+	SysUpper    *time.Time        `db:"sys_upper" json:"sys_upper"`
+	ChangedBy   *string           `db:"changed_by" json:"changed_by"`
+	ChangeSetID *ChangeSetID      `db:"change_set_id" json:"change_set_id"`
+	// Revision numbers count up from 1 for each list, and never change.
+	Revision int `db:"revision" json:"revision"`
+	// Deleted is set if the revision ended because the list was deleted,
+	// rather than replaced by a newer revision.
+	Deleted bool `db:"deleted" json:"deleted"`
+	// RestoredFrom is the revision this revision restored, if it was made by a
+	// restore, undo or redo.
+	RestoredFrom         *TodoListHistoryID `db:"restored_from" json:"restored_from"`
+	RestoredFromRevision *int               `db:"restored_from_revision" json:"restored_from_revision"`
 }
 
 type TodoListRevision struct {
 	TodoListRevisionBase
 	TodoList
-	Todos TodoRevisions
+	Todos TodoRevisions `json:"todos"`
+}
+
+// todosAsOf is when to look up the todos of the revision. Every change to a
+// todo makes a new revision of its list, so the todos are the same throughout a
+// revision, except when compaction has merged revisions. The merged revision
+// has the last state of the ones it replaced, so we look up the todos at its
+// very end, one microsecond (the resolution of timestamps in Postgres) before
+// the next revision starts.
+func (tlr TodoListRevisionBase) todosAsOf() time.Time {
+	if tlr.SysUpper == nil {
+		return tlr.SysLower
+	}
+	return tlr.SysUpper.Add(-time.Microsecond)
+}
+
+// Snapshot returns the todo list as it looked in this revision.
+func (tlr *TodoListRevision) Snapshot() TodoList {
+	return TodoList{
+		TodoListBase: tlr.TodoListBase,
+		Todos:        tlr.Todos.Todos(),
+	}
 }
 
 // hmm, the OnAlias idea broke down here :(
 var todoListRevisionBaseCols = TableColumns{
 	"tlh.history_id", "LOWER(tlh.systime) AS sys_lower", "UPPER(tlh.systime) AS sys_upper",
+	"tlh.changed_by", "tlh.change_set_id", "tlh.revision",
+	// a revision which ends without a newer one right after it was deleted.
+	`(NOT UPPER_INF(tlh.systime)
+     AND NOT EXISTS (SELECT 1
+                     FROM todo_lists_history next
+                     WHERE next.todo_list_id = tlh.todo_list_id
+                       AND LOWER(next.systime) = UPPER(tlh.systime))) AS deleted`,
+	`(SELECT rr.restored_history_id
+     FROM todo_list_restore_revisions rr
+     WHERE rr.history_id = tlh.history_id) AS restored_from`,
+	`(SELECT rtlh.revision
+     FROM todo_list_restore_revisions rr
+     JOIN todo_lists_history rtlh ON rtlh.history_id = rr.restored_history_id
+     WHERE rr.history_id = tlh.history_id) AS restored_from_revision`,
+}
+
+// TodoListRevisionSummary is a revision along with how it relates to the
+// revision before it.
+type TodoListRevisionSummary struct {
+	TodoListRevisionBase
+	PrevHistoryID *TodoListHistoryID `db:"prev_history_id" json:"prev_history_id"`
+	// AfterDeletion is set if the list was deleted before this revision, which
+	// means this revision restored it.
+	AfterDeletion bool `db:"after_deletion" json:"after_deletion"`
+	NameChanged   bool `db:"name_changed" json:"name_changed"`
+	TodosChanged  bool `db:"todos_changed" json:"todos_changed"`
+}
+
+// RevisionChanges filters revisions on what they changed.
+type RevisionChanges string
+
+const (
+	RevisionChangesAll   RevisionChanges = ""
+	RevisionChangesTodos RevisionChanges = "todos"
+	RevisionChangesName  RevisionChanges = "name"
+)
+
+// TodoListRevisionsFilter picks which revisions of a todo list to return. All
+// fields are optional, except for Limit.
+type TodoListRevisionsFilter struct {
+	// AsOf only returns the revisions that existed at that point in time, and
+	// the one valid at that time is returned as open-ended.
+	AsOf *time.Time
+	// From and To limit when the revisions were made. From is inclusive, To is
+	// exclusive.
+	From *time.Time
+	To   *time.Time
+	// Before returns the revisions made before this time, for paging.
+	Before  *time.Time
+	Changes RevisionChanges
+	Limit   int
 }
 
-func GetTodoListRevisions(tx *Tx, tlid TodoListID) ([]TodoListRevisionBase, error) {
-	var revs []TodoListRevisionBase
+// GetTodoListRevisions returns the revisions of a todo list matching the
+// filter, newest first.
+func GetTodoListRevisions(tx *Tx, tlid TodoListID, filter TodoListRevisionsFilter) ([]TodoListRevisionSummary, error) {
+	switch filter.Changes {
+	case RevisionChangesAll, RevisionChangesTodos, RevisionChangesName:
+	default:
+		return nil, fmt.Errorf("unknown revision changes filter %q", filter.Changes)
+	}
+
+	var revs []TodoListRevisionSummary
 	err := tx.Select(&revs, `
-SELECT `+todoListRevisionBaseCols.String()+`
-FROM todo_lists_history tlh
-WHERE tlh.todo_list_id = :tlid
-ORDER BY systime DESC`, QueryArgs{
-		"tlid": tlid,
+SELECT *
+FROM (SELECT `+todoListRevisionBaseCols.String()+`
+           , prev.history_id AS prev_history_id
+           , COALESCE(UPPER(prev.systime) < LOWER(tlh.systime), false) AS after_deletion
+           , COALESCE(prev.name <> tlh.name, false) AS name_changed
+           -- todo changes are made at the same time as the list revision, as
+           -- the list is touched whenever its todos change.
+           , EXISTS (SELECT 1
+                     FROM todos_history th
+                     WHERE th.todo_list_id = tlh.todo_list_id
+                       AND (LOWER(th.systime) = LOWER(tlh.systime)
+                            OR UPPER(th.systime) = LOWER(tlh.systime))) AS todos_changed
+      FROM todo_lists_history tlh
+      LEFT JOIN LATERAL (SELECT p.history_id, p.systime, p.name
+                         FROM todo_lists_history p
+                         WHERE p.todo_list_id = tlh.todo_list_id
+                           AND p.systime << tlh.systime
+                         ORDER BY p.systime DESC
+                         LIMIT 1) AS prev ON true
+      WHERE tlh.todo_list_id = :tlid
+        AND (CAST(:as_of AS timestamptz) IS NULL
+             OR LOWER(tlh.systime) <= CAST(:as_of AS timestamptz))
+        AND (CAST(:from AS timestamptz) IS NULL
+             OR LOWER(tlh.systime) >= CAST(:from AS timestamptz))
+        AND (CAST(:to AS timestamptz) IS NULL
+             OR LOWER(tlh.systime) < CAST(:to AS timestamptz))
+        AND (CAST(:before AS timestamptz) IS NULL
+             OR LOWER(tlh.systime) < CAST(:before AS timestamptz))) AS rev
+WHERE :changes = ''
+   OR (:changes = 'todos' AND rev.todos_changed)
+   OR (:changes = 'name' AND rev.name_changed)
+ORDER BY rev.sys_lower DESC
+LIMIT :limit`, QueryArgs{
+		"tlid":    tlid,
+		"as_of":   filter.AsOf,
+		"from":    filter.From,
+		"to":      filter.To,
+		"before":  filter.Before,
+		"changes": string(filter.Changes),
+		"limit":   filter.Limit,
 	})
 	if err != nil {
 		return nil, err
 	}
+	if filter.AsOf != nil {
+		for i := range revs {
+			if revs[i].SysUpper != nil && revs[i].SysUpper.After(*filter.AsOf) {
+				revs[i].SysUpper = nil
+				revs[i].Deleted = false
+			}
+		}
+	}
 	return revs, nil
 }
 
 var todoListRevisionCols = todoListRevisionBaseCols.Concat(todoListCols.OnAlias("tlh"))
 
-func GetTodoListRevisionAsOf(tx *Tx, tlid TodoListID, asOf time.Time) (*TodoListRevision, error) {
-	// not used anywhere in the current app, but implemented to show how it's
-	// done.
+// GetAllTodoListsAsOf returns the todo lists that existed at the given point in
+// time, as they looked back then.
+func GetAllTodoListsAsOf(tx *Tx, asOf time.Time) ([]TodoListBase, error) {
+	var tls []TodoListBase
+	err := tx.Select(&tls, `
+SELECT `+todoListCols.OnAlias("tlh").String()+`
+FROM todo_lists_history tlh
+WHERE tlh.systime @> CAST(:as_of AS timestamptz)
+ORDER BY name`, QueryArgs{
+		"as_of": asOf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tls, nil
+}
+
+// GetTodoListRevisionAsOf returns the todo list as the database knew it at
+// asOf. If validAsOf is set, the todos are the ones the database at asOf
+// believed were in the list at validAsOf in the real world.
+func GetTodoListRevisionAsOf(tx *Tx, tlid TodoListID, asOf time.Time, validAsOf *time.Time) (*TodoListRevision, error) {
 	var tlr TodoListRevision
 	err := tx.Get(&tlr, `
 SELECT `+todoListRevisionCols.String()+`
 FROM todo_lists_history tlh
 WHERE tlh.todo_list_id = :tlid
-  AND th.systime @> CAST(:as_of AS timestamptz)`, QueryArgs{
+  AND tlh.systime @> CAST(:as_of AS timestamptz)`, QueryArgs{
 		"tlid":  tlid,
 		"as_of": asOf,
 	})
 	if err != nil {
 		return nil, err
 	}
-	err = tlr.attachTodos(tx, asOf)
+	if validAsOf != nil {
+		err = tlr.attachTodosValidAsOf(tx, asOf, *validAsOf)
+	} else {
+		err = tlr.attachTodos(tx, tlr.todosAsOf())
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &tlr, nil
 }
 
+// GetDeletedTodoLists returns the last revision of every todo list that has
+// been deleted, most recently deleted first.
+func GetDeletedTodoLists(tx *Tx) ([]TodoListRevision, error) {
+	var tlrs []TodoListRevision
+	err := tx.Select(&tlrs, `
+SELECT *
+FROM (SELECT DISTINCT ON (tlh.todo_list_id) `+todoListRevisionCols.String()+`
+      FROM todo_lists_history tlh
+      WHERE NOT EXISTS (SELECT 1
+                        FROM todo_lists tl
+                        WHERE tl.todo_list_id = tlh.todo_list_id)
+      ORDER BY tlh.todo_list_id, tlh.systime DESC) AS latest
+WHERE latest.sys_upper IS NOT NULL
+ORDER BY latest.sys_upper DESC`, QueryArgs{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range tlrs {
+		err = tlrs[i].attachTodos(tx, tlrs[i].todosAsOf())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tlrs, nil
+}
+
+// PurgeTodoList permanently removes all history of a deleted todo list. There
+// is no way back from this.
+func PurgeTodoList(tx *Tx, tlid TodoListID) error {
+	var live bool
+	err := tx.Get(&live, `
+SELECT EXISTS (SELECT 1
+               FROM todo_lists tl
+               WHERE tl.todo_list_id = :tlid)`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return err
+	}
+	if live {
+		return fmt.Errorf("todo list %s has not been deleted", tlid)
+	}
+
+	err = tx.Exec(`
+DELETE FROM todo_list_redo_stack
+WHERE todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Exec(`
+DELETE FROM todo_list_checkpoints
+WHERE todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Exec(`
+DELETE FROM todo_list_restore_revisions rr
+USING todo_lists_history tlh
+WHERE rr.history_id = tlh.history_id
+  AND tlh.todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Exec(`
+DELETE FROM todo_list_undo_revisions uv
+USING todo_lists_history tlh
+WHERE uv.history_id = tlh.history_id
+  AND tlh.todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return err
+	}
+	// so that verify-history knows the chains are gone on purpose.
+	err = anchorHistoryChains(tx, "todos_history", "todo_id", `
+(SELECT th.* FROM todos_history th WHERE th.todo_list_id = :tlid)`, QueryArgs{
+		"tlid": tlid,
+	}, "purged")
+	if err != nil {
+		return err
+	}
+	err = anchorHistoryChains(tx, "todo_lists_history", "todo_list_id", `
+(SELECT tlh.* FROM todo_lists_history tlh WHERE tlh.todo_list_id = :tlid)`, QueryArgs{
+		"tlid": tlid,
+	}, "purged")
+	if err != nil {
+		return err
+	}
+	err = tx.Exec(`
+DELETE FROM todos_history
+WHERE todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Exec(`
+DELETE FROM todo_lists_history
+WHERE todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+	return err
+}
+
 func GetTodoListRevisionByID(tx *Tx, tlhid TodoListHistoryID) (*TodoListRevision, error) {
 	var tlr TodoListRevision
 	err := tx.Get(&tlr, `
@@ -73,7 +340,27 @@ WHERE tlh.history_id = :tlhid`, QueryArgs{
 	if err != nil {
 		return nil, err
 	}
-	err = tlr.attachTodos(tx, tlr.SysLower)
+	err = tlr.attachTodos(tx, tlr.todosAsOf())
+	if err != nil {
+		return nil, err
+	}
+	return &tlr, nil
+}
+
+func GetTodoListRevisionByNumber(tx *Tx, tlid TodoListID, revision int) (*TodoListRevision, error) {
+	var tlr TodoListRevision
+	err := tx.Get(&tlr, `
+SELECT `+todoListRevisionCols.String()+`
+FROM todo_lists_history tlh
+WHERE tlh.todo_list_id = :tlid
+  AND tlh.revision = :revision`, QueryArgs{
+		"tlid":     tlid,
+		"revision": revision,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = tlr.attachTodos(tx, tlr.todosAsOf())
 	if err != nil {
 		return nil, err
 	}
@@ -107,24 +394,134 @@ WHERE tlh.history_id = :tlhid`, QueryArgs{
 	// then insert the list elements
 	err = tx.Exec(`
 INSERT INTO todos (`+todoCols.String()+`)
-SELECT `+todoCols.OnAlias("th").String()+`
+SELECT `+restoredTodoCols.String()+`
 FROM todos_history th
 WHERE th.todo_list_id = :list_id
   AND th.systime @> CAST(:as_of AS timestamptz)`, QueryArgs{
 		"list_id": tlr.ID,
-		"as_of":   tlr.SysLower,
+		"as_of":   tlr.todosAsOf(),
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	// and finally record which revision the new one was restored from
+	err = tx.UpdateOne(`
+INSERT INTO todo_list_restore_revisions (history_id, restored_history_id)
+SELECT tlh.history_id, :tlhid
+FROM todo_lists_history tlh
+WHERE tlh.todo_list_id = :tlid
+  AND UPPER_INF(tlh.systime)`, QueryArgs{
+		"tlid":  tlr.ID,
+		"tlhid": tlhid,
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &tlr.ID, nil
 }
 
+// ForkTodoListRevision copies a revision into a brand new todo list, leaving the
+// original list as it is. The todos get new IDs, so the two lists have
+// independent histories from here on.
+func ForkTodoListRevision(tx *Tx, tlhid TodoListHistoryID) (*TodoListID, error) {
+	tlr, err := GetTodoListRevisionByID(tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlid TodoListID
+	err = tx.Get(&tlid, `
+INSERT INTO todo_lists (name, forked_from_history_id)
+VALUES (:name, :tlhid)
+RETURNING todo_list_id`, QueryArgs{
+		"name":  tlr.Name + " (fork)",
+		"tlhid": tlhid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Exec(`
+INSERT INTO todos (todo_list_id, description, completed)
+SELECT :new_list_id, th.description, th.completed
+FROM todos_history th
+WHERE th.todo_list_id = :list_id
+  AND th.systime @> CAST(:as_of AS timestamptz)`, QueryArgs{
+		"new_list_id": tlid,
+		"list_id":     tlr.ID,
+		"as_of":       tlr.todosAsOf(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tlid, nil
+}
+
+// TodoListRestore is what restoring a todo list revision does to the list.
+type TodoListRestore struct {
+	Revision TodoListRevisionBase `json:"revision"`
+	// ListDeleted is set if the list is deleted, and restoring it brings it
+	// back.
+	ListDeleted bool         `json:"list_deleted"`
+	Changes     TodoListDiff `json:"changes"`
+}
+
+// PreviewTodoListRestore restores the revision and reports what happened, but
+// rolls back the restore afterwards.
+func PreviewTodoListRestore(tx *Tx, tlhid TodoListHistoryID) (*TodoListRestore, error) {
+	var restore *TodoListRestore
+	err := tx.DryRun(func() error {
+		var err error
+		restore, err = RestoreTodoListToRevisionWithChanges(tx, tlhid)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restore, nil
+}
+
+// RestoreTodoListToRevisionWithChanges restores the revision like
+// RestoreTodoListToRevision, and reports what it did to the list.
+func RestoreTodoListToRevisionWithChanges(tx *Tx, tlhid TodoListHistoryID) (*TodoListRestore, error) {
+	tlr, err := GetTodoListRevisionByID(tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+
+	restore := TodoListRestore{
+		Revision: tlr.TodoListRevisionBase,
+	}
+	before, err := GetTodoListByID(tx, tlr.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		restore.ListDeleted = true
+		// compare with an empty list which has the same name, so that the
+		// changes only show the todos coming back.
+		before = &TodoList{TodoListBase: tlr.TodoListBase}
+	} else if err != nil {
+		return nil, err
+	}
+
+	_, err = RestoreTodoListToRevision(tx, tlhid)
+	if err != nil {
+		return nil, err
+	}
+	after, err := GetTodoListByID(tx, tlr.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	restore.Changes = DiffTodoLists(*before, *after)
+	return &restore, nil
+}
+
 type TodoRevision struct {
-	HistoryID TodoHistoryID `db:"history_id"`
-	SysLower  time.Time     `db:"sys_lower"`
-	SysUpper  *time.Time    `db:"sys_upper"`
+	HistoryID   TodoHistoryID `db:"history_id" json:"history_id"`
+	SysLower    time.Time     `db:"sys_lower" json:"sys_lower"`
+	SysUpper    *time.Time    `db:"sys_upper" json:"sys_upper"`
+	ChangedBy   *string       `db:"changed_by" json:"changed_by"`
+	ChangeSetID *ChangeSetID  `db:"change_set_id" json:"change_set_id"`
 	Todo
 }
 
@@ -140,24 +537,77 @@ func (ts TodoRevisions) FilterByCompleted(completed bool) TodoRevisions {
 	return res
 }
 
+// Todos strips away the revision information.
+func (ts TodoRevisions) Todos() Todos {
+	res := make(Todos, len(ts))
+	for i, todo := range ts {
+		res[i] = todo.Todo
+	}
+	return res
+}
+
 var todoRevisionBaseCols = TableColumns{
 	"th.history_id", "LOWER(th.systime) AS sys_lower", "UPPER(th.systime) AS sys_upper",
+	"th.changed_by", "th.change_set_id",
 }
 
 var todoRevisionCols = todoRevisionBaseCols.Concat(todoCols.OnAlias("th"))
 
+// restoredTodoCols selects todoCols from the history row th when putting a todo
+// back. The valid time of a superseded row has ended, so the restored todo is
+// valid from now on instead.
+var restoredTodoCols = TableColumns{
+	"th.todo_id", "th.todo_list_id", "th.description", "th.created_at", "th.completed",
+	"tstzrange(NOW(), NULL)",
+}
+
 func (tlr *TodoListRevision) attachTodos(tx *Tx, asOf time.Time) error {
 	// passing in asOf doesn't really do anthing valuable in this implementation:
 	// Since we always update the todo list's updated_at field whenever we do
-	// something with its todos, we may as well use the creation time of the
-	// revision. However, if you don't need to maintain a list of revisions for
-	// the list itself, but is rather interested in the state at a specific point
-	// in time, you need the asOf as input.
+	// something with its todos, we may as well use any point in time within
+	// the revision (see todosAsOf for which one we use). However, if you don't
+	// need to maintain a list of revisions for the list itself, but is rather
+	// interested in the state at a specific point in time, you need the asOf as
+	// input.
+	//
+	// The todos come from the nearest checkpoint before asOf, if there is one:
+	// Any row current at asOf either started before the checkpoint, and then
+	// it's in it, or it started after it.
 	err := tx.Select(&tlr.Todos, `
-SELECT `+todoRevisionCols.String()+`
-FROM todos_history th
-WHERE th.todo_list_id = :tlid
-  AND th.systime @> CAST(:as_of AS timestamptz)
+WITH cp AS (
+  SELECT c.taken_at, c.todo_history_ids
+  FROM todo_list_checkpoints c
+  WHERE c.todo_list_id = :tlid
+    AND c.taken_at <= CAST(:as_of AS timestamptz)
+  ORDER BY c.taken_at DESC
+  LIMIT 1
+)
+SELECT *
+FROM (SELECT `+todoRevisionCols.String()+`
+      FROM cp
+      JOIN todos_history th
+        ON th.history_id = ANY(cp.todo_history_ids)
+      WHERE th.todo_list_id = :tlid
+        AND th.systime @> CAST(:as_of AS timestamptz)
+
+      UNION ALL
+
+      SELECT `+todoRevisionCols.String()+`
+      FROM cp
+      JOIN todos_history th
+        ON th.todo_list_id = :tlid
+       AND LOWER(th.systime) > cp.taken_at
+       AND LOWER(th.systime) <= CAST(:as_of AS timestamptz)
+      WHERE th.systime @> CAST(:as_of AS timestamptz)
+
+      UNION ALL
+
+      -- no checkpoint yet
+      SELECT `+todoRevisionCols.String()+`
+      FROM todos_history th
+      WHERE NOT EXISTS (SELECT 1 FROM cp)
+        AND th.todo_list_id = :tlid
+        AND th.systime @> CAST(:as_of AS timestamptz)) AS th
 ORDER BY th.description ASC`, QueryArgs{
 		"tlid":  tlr.ID,
 		"as_of": asOf,
@@ -165,6 +615,68 @@ ORDER BY th.description ASC`, QueryArgs{
 	return err
 }
 
+// attachTodosValidAsOf attaches the todos the database knew about at asOf, as
+// they were at validAsOf in the real world. A todo can have several revisions
+// valid at validAsOf, as later revisions may correct earlier ones by being
+// backdated, and then the most recently recorded one wins.
+//
+// A row's valid_time is closed when it's superseded, so for rows that were
+// still current at asOf, that wasn't known yet and they count as valid until
+// further notice. Todos deleted by asOf are left out if they were deleted
+// before validAsOf, as they didn't exist at that point.
+func (tlr *TodoListRevision) attachTodosValidAsOf(tx *Tx, asOf, validAsOf time.Time) error {
+	err := tx.Select(&tlr.Todos, `
+SELECT *
+FROM (SELECT DISTINCT ON (th.todo_id) `+todoRevisionCols.String()+`
+      FROM todos_history th
+      WHERE th.todo_list_id = :tlid
+        AND `+validAsOfCondition+`
+      ORDER BY th.todo_id, th.systime DESC) AS th
+WHERE NOT EXISTS (`+deletedBeforeQuery+`)
+ORDER BY th.description ASC`, QueryArgs{
+		"tlid":        tlr.ID,
+		"as_of":       asOf,
+		"valid_as_of": validAsOf,
+	})
+	if err != nil {
+		return err
+	}
+	for i := range tlr.Todos {
+		tlr.Todos[i].asKnownAt(asOf)
+	}
+	return nil
+}
+
+// validAsOfCondition picks the history rows recorded by :as_of that were valid
+// at :valid_as_of, as far as the database knew at :as_of.
+const validAsOfCondition = `LOWER(th.systime) <= CAST(:as_of AS timestamptz)
+        AND LOWER(th.valid_time) <= CAST(:valid_as_of AS timestamptz)
+        AND (UPPER_INF(th.valid_time)
+             OR UPPER(th.valid_time) > CAST(:valid_as_of AS timestamptz)
+             OR UPPER(th.systime) > CAST(:as_of AS timestamptz))`
+
+// deletedBeforeQuery finds a deletion of the todo th after the row th was
+// recorded, which happened by both :as_of and :valid_as_of. Deletions have no
+// valid time of their own, so they're taken to have happened when recorded.
+const deletedBeforeQuery = `SELECT 1
+                 FROM todos_history gone
+                 WHERE gone.todo_id = th.todo_id
+                   AND LOWER(gone.systime) >= th.sys_lower
+                   AND UPPER(gone.systime) <= LEAST(CAST(:as_of AS timestamptz), CAST(:valid_as_of AS timestamptz))
+                   AND NOT EXISTS (SELECT 1
+                                   FROM todos_history next
+                                   WHERE next.todo_id = gone.todo_id
+                                     AND LOWER(next.systime) = UPPER(gone.systime))`
+
+// asKnownAt removes what the revision learned after asOf: When it was closed,
+// and with it, when it stopped being valid.
+func (tr *TodoRevision) asKnownAt(asOf time.Time) {
+	if tr.SysUpper != nil && tr.SysUpper.After(asOf) {
+		tr.SysUpper = nil
+		tr.ValidTime.To = time.Time{}
+	}
+}
+
 func GetTodoRevisionByID(tx *Tx, thid TodoHistoryID) (*TodoRevision, error) {
 	var tr TodoRevision
 	err := tx.Get(&tr, `
@@ -179,47 +691,114 @@ WHERE th.history_id = :thid`, QueryArgs{
 	return &tr, nil
 }
 
-func GetTodoRevisionAsOf(tx *Tx, tid TodoID, asOf time.Time) (*TodoRevision, error) {
-	// not used anywhere in the app as of right now, but present to show you how
-	// it's implemented.
-	var tr TodoRevision
-	err := tx.Get(&tr, `
+// GetTodoRevisions returns the revisions of a single todo, newest first. As
+// with GetTodoListRevisions, asOf limits the revisions to the ones that existed
+// at that point in time.
+func GetTodoRevisions(tx *Tx, tid TodoID, asOf *time.Time) (TodoRevisions, error) {
+	var trs TodoRevisions
+	err := tx.Select(&trs, `
 SELECT `+todoRevisionCols.String()+`
 FROM todos_history th
 WHERE th.todo_id = :tid
-  AND th.systime @> CAST(:as_of AS timestamptz)`, QueryArgs{
+  AND (CAST(:as_of AS timestamptz) IS NULL
+       OR LOWER(th.systime) <= CAST(:as_of AS timestamptz))
+ORDER BY th.systime DESC`, QueryArgs{
 		"tid":   tid,
 		"as_of": asOf,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return &tr, nil
+	if asOf != nil {
+		for i := range trs {
+			trs[i].asKnownAt(*asOf)
+		}
+	}
+	return trs, nil
 }
 
-func RestoreTodoToRevision(tx *Tx, thid TodoHistoryID) (*TodoListID, error) {
-	tr, err := GetTodoRevisionByID(tx, thid)
+// GetTodoRevisionAsOf returns the todo as the database knew it at asOf. If
+// validAsOf is set, it's the todo as the database at asOf believed it was at
+// validAsOf in the real world, see attachTodosValidAsOf.
+func GetTodoRevisionAsOf(tx *Tx, tid TodoID, asOf time.Time, validAsOf *time.Time) (*TodoRevision, error) {
+	// not used anywhere in the app as of right now, but present to show you how
+	// it's implemented.
+	var tr TodoRevision
+	err := tx.Get(&tr, `
+SELECT th.*
+FROM (SELECT `+todoRevisionCols.String()+`
+      FROM todos_history th
+      WHERE th.todo_id = :tid
+        AND (CAST(:valid_as_of AS timestamptz) IS NULL
+             AND th.systime @> CAST(:as_of AS timestamptz)
+             OR `+validAsOfCondition+`)
+      ORDER BY th.systime DESC
+      LIMIT 1) AS th
+WHERE CAST(:valid_as_of AS timestamptz) IS NULL
+   OR NOT EXISTS (`+deletedBeforeQuery+`)`, QueryArgs{
+		"tid":         tid,
+		"as_of":       asOf,
+		"valid_as_of": validAsOf,
+	})
 	if err != nil {
 		return nil, err
 	}
+	tr.asKnownAt(asOf)
+	return &tr, nil
+}
 
-	// delete it (if it still is in the list)
-	err = DeleteTodo(tx, tr.ID)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return nil, err
+func RestoreTodoToRevision(tx *Tx, thid TodoHistoryID) (*TodoListID, error) {
+	return RestoreTodosToRevisions(tx, []TodoHistoryID{thid})
+}
+
+// RestoreTodosToRevisions restores several todos in one go, making a single new
+// revision of the todo list they are in. The todo revisions must all be from
+// the same list, and be for different todos.
+func RestoreTodosToRevisions(tx *Tx, thids []TodoHistoryID) (*TodoListID, error) {
+	if len(thids) == 0 {
+		return nil, errors.New("no todos to restore")
 	}
 
-	// then insert it back into the list
-	err = tx.Exec(`
+	var listID TodoListID
+	restored := map[TodoID]bool{}
+	for i, thid := range thids {
+		tr, err := GetTodoRevisionByID(tx, thid)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			listID = tr.ListID
+		}
+		if tr.ListID != listID {
+			return nil, fmt.Errorf("todo revision %s is not from todo list %s", thid, listID)
+		}
+		if restored[tr.ID] {
+			return nil, fmt.Errorf("todo %s is restored more than once", tr.ID)
+		}
+		restored[tr.ID] = true
+
+		// delete it (if it still is in the list)
+		err = deleteTodo(tx, tr.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// then insert it back into the list
+		err = tx.Exec(`
 INSERT INTO todos (`+todoCols.String()+`)
-SELECT `+todoCols.OnAlias("th").String()+`
+SELECT `+restoredTodoCols.String()+`
 FROM todos_history th
 WHERE th.history_id = :thid`, QueryArgs{
-		"thid": thid,
-	})
+			"thid": thid,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	err := touchTodoList(tx, listID)
 	if err != nil {
 		return nil, err
 	}
-	return &tr.ListID, nil
+	return &listID, nil
 }
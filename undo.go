@@ -0,0 +1,211 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Undo and redo are built on top of the todo list revisions: Every change set
+// that touches a todo list makes a new revision of it, so undoing a change set
+// means restoring the list to the revision before it.
+//
+// Revisions made by undo and redo are recorded in todo_list_undo_revisions
+// along with the revision they restored. When we look for the revision to undo
+// to, we always go from the restored revision, which makes repeated undos walk
+// further back in time. What was undone is pushed onto todo_list_redo_stack,
+// and is only available as long as the current revision was made by an undo or
+// a redo.
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// UndoState tells whether undo and redo is possible for a todo list.
+type UndoState struct {
+	CanUndo bool `db:"can_undo"`
+	CanRedo bool `db:"can_redo"`
+}
+
+func GetUndoState(tx *Tx, tlid TodoListID) (*UndoState, error) {
+	var state UndoState
+	err := tx.Get(&state, `
+WITH cur AS (
+  SELECT tlh.history_id,
+         uv.restored_history_id IS NOT NULL AS made_by_undo,
+         COALESCE(uv.restored_history_id, tlh.history_id) AS logical_id
+  FROM todo_lists_history tlh
+  LEFT JOIN todo_list_undo_revisions uv ON uv.history_id = tlh.history_id
+  WHERE tlh.todo_list_id = :tlid
+    AND UPPER_INF(tlh.systime)
+)
+SELECT EXISTS (SELECT 1
+               FROM cur
+               JOIN todo_lists_history logical ON logical.history_id = cur.logical_id
+               JOIN todo_lists_history prev
+                 ON prev.todo_list_id = logical.todo_list_id
+                AND LOWER(prev.systime) < LOWER(logical.systime)) AS can_undo
+     , EXISTS (SELECT 1
+               FROM cur
+               JOIN todo_list_redo_stack rs ON rs.todo_list_id = :tlid
+               WHERE cur.made_by_undo) AS can_redo`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// UndoTodoList reverts the todo list to the state before the change set that
+// made its current revision.
+func UndoTodoList(tx *Tx, tlid TodoListID) error {
+	cur, err := lockCurrentUndoRevision(tx, tlid)
+	if err != nil {
+		return err
+	}
+	if !cur.MadeByUndo {
+		err = clearRedoStack(tx, tlid)
+		if err != nil {
+			return err
+		}
+	}
+
+	var prev TodoListHistoryID
+	err = tx.Get(&prev, `
+SELECT COALESCE(uv.restored_history_id, prev.history_id)
+FROM todo_lists_history logical
+JOIN todo_lists_history prev
+  ON prev.todo_list_id = logical.todo_list_id
+ AND LOWER(prev.systime) < LOWER(logical.systime)
+LEFT JOIN todo_list_undo_revisions uv ON uv.history_id = prev.history_id
+WHERE logical.history_id = :logical_id
+ORDER BY prev.systime DESC
+LIMIT 1`, QueryArgs{
+		"logical_id": cur.LogicalID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNothingToUndo
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec(`
+INSERT INTO todo_list_redo_stack (todo_list_id, position, history_id)
+SELECT :tlid, COALESCE(MAX(rs.position), 0) + 1, :logical_id
+FROM todo_list_redo_stack rs
+WHERE rs.todo_list_id = :tlid`, QueryArgs{
+		"tlid":       tlid,
+		"logical_id": cur.LogicalID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return restoreForUndo(tx, prev)
+}
+
+// RedoTodoList reapplies the most recently undone change set, provided nothing
+// else has happened to the todo list since it was undone.
+func RedoTodoList(tx *Tx, tlid TodoListID) error {
+	cur, err := lockCurrentUndoRevision(tx, tlid)
+	if err != nil {
+		return err
+	}
+	if !cur.MadeByUndo {
+		err = clearRedoStack(tx, tlid)
+		if err != nil {
+			return err
+		}
+		return ErrNothingToRedo
+	}
+
+	var next TodoListHistoryID
+	err = tx.Get(&next, `
+DELETE FROM todo_list_redo_stack
+WHERE todo_list_id = :tlid
+  AND position = (SELECT MAX(rs.position)
+                  FROM todo_list_redo_stack rs
+                  WHERE rs.todo_list_id = :tlid)
+RETURNING history_id`, QueryArgs{
+		"tlid": tlid,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNothingToRedo
+	}
+	if err != nil {
+		return err
+	}
+
+	return restoreForUndo(tx, next)
+}
+
+type undoRevision struct {
+	HistoryID  TodoListHistoryID `db:"history_id"`
+	LogicalID  TodoListHistoryID `db:"logical_id"`
+	MadeByUndo bool              `db:"made_by_undo"`
+}
+
+// lockCurrentUndoRevision returns the current revision of the todo list, and
+// locks the list so that concurrent undos and redos don't mess up the redo
+// stack.
+func lockCurrentUndoRevision(tx *Tx, tlid TodoListID) (*undoRevision, error) {
+	var lockedID TodoListID
+	err := tx.Get(&lockedID, `
+SELECT todo_list_id
+FROM todo_lists
+WHERE todo_list_id = :tlid
+FOR UPDATE`, QueryArgs{
+		"tlid": tlid,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("todo list %s does not exist", tlid)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cur undoRevision
+	err = tx.Get(&cur, `
+SELECT tlh.history_id
+     , COALESCE(uv.restored_history_id, tlh.history_id) AS logical_id
+     , uv.restored_history_id IS NOT NULL AS made_by_undo
+FROM todo_lists_history tlh
+LEFT JOIN todo_list_undo_revisions uv ON uv.history_id = tlh.history_id
+WHERE tlh.todo_list_id = :tlid
+  AND UPPER_INF(tlh.systime)`, QueryArgs{
+		"tlid": tlid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+func clearRedoStack(tx *Tx, tlid TodoListID) error {
+	return tx.Exec(`
+DELETE FROM todo_list_redo_stack
+WHERE todo_list_id = :tlid`, QueryArgs{
+		"tlid": tlid,
+	})
+}
+
+// restoreForUndo restores the todo list to the given revision, and records that
+// the new revision was made by undo or redo.
+func restoreForUndo(tx *Tx, tlhid TodoListHistoryID) error {
+	tlid, err := RestoreTodoListToRevision(tx, tlhid)
+	if err != nil {
+		return err
+	}
+	return tx.UpdateOne(`
+INSERT INTO todo_list_undo_revisions (history_id, restored_history_id)
+SELECT tlh.history_id, :tlhid
+FROM todo_lists_history tlh
+WHERE tlh.todo_list_id = :tlid
+  AND UPPER_INF(tlh.systime)`, QueryArgs{
+		"tlid":  tlid,
+		"tlhid": tlhid,
+	})
+}